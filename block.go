@@ -0,0 +1,71 @@
+package lz4
+
+import (
+	"fmt"
+	"io"
+)
+
+// maxBlockInput is the largest input the block format supports.
+const maxBlockInput = 0x7E000000
+
+// CompressBlockBound returns the maximum size of the compressed form of n
+// bytes, which is the size dst needs to be for CompressBlock to always
+// succeed.
+func CompressBlockBound(n int) int {
+	return compressBound(n)
+}
+
+// CompressBlock compresses src into dst using the LZ4 block format, without
+// any frame around it, and returns the number of bytes written to dst. The
+// size of src is not recorded and has to be stored separately to decompress
+// the block. If dst is smaller than CompressBlockBound(len(src)) and the
+// compressed data does not fit, io.ErrShortBuffer is returned.
+func CompressBlock(src, dst []byte) (int, error) {
+	return CompressBlockLevel(src, dst, defaultCompression)
+}
+
+// CompressBlockLevel is like CompressBlock but specifies the compression
+// level, as NewWriterLevel does.
+func CompressBlockLevel(src, dst []byte, level int) (int, error) {
+	if level < defaultCompression || level > BestCompression {
+		return 0, fmt.Errorf("lz4: invalid compression level: %d", level)
+	}
+	if len(src) > maxBlockInput {
+		return 0, ErrBlockTooLarge
+	}
+	if len(src) == 0 {
+		// A block holding no data is made of a single empty token.
+		if len(dst) == 0 {
+			return 0, io.ErrShortBuffer
+		}
+		dst[0] = 0
+		return 1, nil
+	}
+	var (
+		n   int
+		err error
+	)
+	if level >= minHCCompression {
+		n, err = lz4CompressHC(src, dst, nil, level)
+	} else {
+		n, err = lz4CompressSpeed(src, dst, nil, 1)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, io.ErrShortBuffer
+	}
+	return n, nil
+}
+
+// UncompressBlock decompresses the LZ4 block src into dst and returns the
+// number of bytes written to dst. It never writes more than len(dst) bytes:
+// a block decompressing to more data than dst holds is reported as an error,
+// as is any corruption of src.
+func UncompressBlock(src, dst []byte) (int, error) {
+	if len(src) == 0 {
+		return 0, ErrCorrupt
+	}
+	return lz4Decompress(src, dst, nil)
+}
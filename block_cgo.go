@@ -0,0 +1,89 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
+package lz4
+
+/*
+#cgo LDFLAGS: -llz4
+#cgo CFLAGS: -O3
+#include <stdlib.h>
+#include "lz4.h"
+#include "lz4hc.h"
+
+// The streaming state references the dictionary, so it is created, used and
+// released within a single call to keep Go memory out of C structures.
+static int lz4_compress_dict(const char* dict, int dictSize, const char* src, char* dst, int srcSize, int dstCapacity, int acceleration) {
+	LZ4_stream_t* stream = LZ4_createStream();
+	if (stream == NULL) {
+		return 0;
+	}
+	LZ4_loadDict(stream, dict, dictSize);
+	int n = LZ4_compress_fast_continue(stream, src, dst, srcSize, dstCapacity, acceleration);
+	LZ4_freeStream(stream);
+	return n;
+}
+
+static int lz4_compress_hc_dict(const char* dict, int dictSize, const char* src, char* dst, int srcSize, int dstCapacity, int level) {
+	LZ4_streamHC_t* stream = LZ4_createStreamHC();
+	if (stream == NULL) {
+		return 0;
+	}
+	LZ4_resetStreamHC_fast(stream, level);
+	LZ4_loadDictHC(stream, dict, dictSize);
+	int n = LZ4_compress_HC_continue(stream, src, dst, srcSize, dstCapacity);
+	LZ4_freeStreamHC(stream);
+	return n;
+}
+*/
+import "C"
+
+import "unsafe"
+
+func ptr(b []byte) *C.char {
+	if len(b) == 0 {
+		return nil
+	}
+	return (*C.char)(unsafe.Pointer(&b[0]))
+}
+
+// lz4CompressSpeed compresses src into dst, using dict as the data
+// preceding src. Higher accelerations skip more of the incompressible data.
+// It returns 0 if the compressed block does not fit in dst.
+func lz4CompressSpeed(src []byte, dst []byte, dict []byte, acceleration int) (int, error) {
+	if len(src) == 0 || len(dst) == 0 {
+		return 0, nil
+	}
+	var n C.int
+	if len(dict) > 0 {
+		n = C.lz4_compress_dict(ptr(dict), C.int(len(dict)), ptr(src), ptr(dst), C.int(len(src)), C.int(len(dst)), C.int(acceleration))
+	} else {
+		n = C.LZ4_compress_fast(ptr(src), ptr(dst), C.int(len(src)), C.int(len(dst)), C.int(acceleration))
+	}
+	return int(n), nil
+}
+
+// lz4CompressHC is like lz4CompressSpeed but uses the high compression
+// compressor at the given level, from 3 to 12, trading speed for a better
+// compression ratio.
+func lz4CompressHC(src []byte, dst []byte, dict []byte, level int) (int, error) {
+	if len(src) == 0 || len(dst) == 0 {
+		return 0, nil
+	}
+	var n C.int
+	if len(dict) > 0 {
+		n = C.lz4_compress_hc_dict(ptr(dict), C.int(len(dict)), ptr(src), ptr(dst), C.int(len(src)), C.int(len(dst)), C.int(level))
+	} else {
+		n = C.LZ4_compress_HC(ptr(src), ptr(dst), C.int(len(src)), C.int(len(dst)), C.int(level))
+	}
+	return int(n), nil
+}
+
+// lz4Decompress decompresses src into dst, using dict as the data preceding
+// the block.
+func lz4Decompress(src []byte, dst []byte, dict []byte) (int, error) {
+	n := C.LZ4_decompress_safe_usingDict(ptr(src), ptr(dst), C.int(len(src)), C.int(len(dst)), ptr(dict), C.int(len(dict)))
+	if n < 0 {
+		return 0, ErrCorrupt
+	}
+	return int(n), nil
+}
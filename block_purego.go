@@ -0,0 +1,429 @@
+//go:build !cgo || purego
+// +build !cgo purego
+
+package lz4
+
+import "encoding/binary"
+
+const (
+	minMatch     = 4
+	lastLiterals = 5
+	mfLimit      = 12
+	minLength    = mfLimit + 1
+	maxDistance  = 65535
+	skipStrength = 6
+	hashLog      = 12
+	hcHashLog    = 15
+	mlBits       = 4
+	mlMask       = 1<<mlBits - 1
+	runMask      = 1<<(8-mlBits) - 1
+)
+
+// hcDepth is the number of candidates examined by the high compression
+// compressor to find a match, by compression level.
+var hcDepth = [...]int{
+	3: 4, 4: 8, 5: 16, 6: 32, 7: 64, 8: 128, 9: 256,
+	10: 1024, 11: 4096, 12: 16384,
+}
+
+// lz4CompressSpeed compresses src into dst, using dict as the data
+// preceding src. Higher accelerations skip more of the incompressible data.
+// It returns 0 if the compressed block does not fit in dst.
+func lz4CompressSpeed(src []byte, dst []byte, dict []byte, acceleration int) (int, error) {
+	if len(src) == 0 {
+		return 0, nil
+	}
+	if len(dict) == 0 {
+		return compressBlock(src, dst, 0, acceleration), nil
+	}
+	if len(dict) > maxDistance {
+		dict = dict[len(dict)-maxDistance:]
+	}
+	buf := make([]byte, 0, len(dict)+len(src))
+	buf = append(append(buf, dict...), src...)
+	return compressBlock(buf, dst, len(dict), acceleration), nil
+}
+
+// lz4CompressHC is like lz4CompressSpeed but uses the high compression
+// compressor at the given level, from 3 to 12, trading speed for a better
+// compression ratio.
+func lz4CompressHC(src []byte, dst []byte, dict []byte, level int) (int, error) {
+	if len(src) == 0 {
+		return 0, nil
+	}
+	if len(dict) == 0 {
+		return compressBlockHC(src, dst, 0, hcDepth[level]), nil
+	}
+	if len(dict) > maxDistance {
+		dict = dict[len(dict)-maxDistance:]
+	}
+	buf := make([]byte, 0, len(dict)+len(src))
+	buf = append(append(buf, dict...), src...)
+	return compressBlockHC(buf, dst, len(dict), hcDepth[level]), nil
+}
+
+// lz4Decompress decompresses src into dst, using dict as the data preceding
+// the block.
+func lz4Decompress(src []byte, dst []byte, dict []byte) (int, error) {
+	return decompressBlock(src, dst, dict)
+}
+
+func hashSequence(sequence uint32, log uint) uint32 {
+	return (sequence * 2654435761) >> (32 - log)
+}
+
+// compressBlock compresses src[start:] into dst using the LZ4 block format,
+// with src[:start] as the data preceding it. It returns the number of bytes
+// written to dst, or 0 if the compressed block does not fit into dst.
+func compressBlock(src, dst []byte, start, acceleration int) int {
+	// Small inputs only need positions that fit in 16 bits, and therefore
+	// get twice as many hash entries in the same amount of memory.
+	log := uint(hashLog)
+	if len(src) < 1<<16+mfLimit-1 {
+		log++
+	}
+	var table [1 << (hashLog + 1)]int32
+
+	var (
+		ip, anchor, op = start, start, 0
+		mflimit        = len(src) - mfLimit
+		matchlimit     = len(src) - lastLiterals
+	)
+	if len(src)-start < minLength {
+		goto lastLiteral
+	}
+
+	for i := 0; i+minMatch <= start; i++ {
+		table[hashSequence(binary.LittleEndian.Uint32(src[i:]), log)] = int32(i)
+	}
+	table[hashSequence(binary.LittleEndian.Uint32(src[ip:]), log)] = int32(ip)
+	ip++
+	for {
+		// Find a match
+		var ref int
+		h := hashSequence(binary.LittleEndian.Uint32(src[ip:]), log)
+		forwardIP, attempts := ip, acceleration<<skipStrength+3
+		for {
+			ip = forwardIP
+			forwardIP += attempts >> skipStrength
+			attempts++
+			if forwardIP > mflimit {
+				goto lastLiteral
+			}
+			ref = int(table[h])
+			table[h] = int32(ip)
+			h = hashSequence(binary.LittleEndian.Uint32(src[forwardIP:]), log)
+			if ref+maxDistance >= ip &&
+				binary.LittleEndian.Uint32(src[ref:]) == binary.LittleEndian.Uint32(src[ip:]) {
+				break
+			}
+		}
+
+		// Catch up
+		for ip > anchor && ref > 0 && src[ip-1] == src[ref-1] {
+			ip--
+			ref--
+		}
+
+		// Encode literal length
+		litLength := ip - anchor
+		if op+litLength+(2+1+lastLiterals)+litLength/255 > len(dst) {
+			return 0
+		}
+		token := op
+		op++
+		if litLength >= runMask {
+			dst[token] = runMask << mlBits
+			op = writeLength(dst, op, litLength-runMask)
+		} else {
+			dst[token] = byte(litLength << mlBits)
+		}
+		op += copy(dst[op:], src[anchor:ip])
+
+		for {
+			// Encode offset
+			binary.LittleEndian.PutUint16(dst[op:], uint16(ip-ref))
+			op += 2
+
+			// Encode match length
+			ip += minMatch
+			ref += minMatch
+			matchLength := 0
+			for ip+matchLength < matchlimit && src[ip+matchLength] == src[ref+matchLength] {
+				matchLength++
+			}
+			ip += matchLength
+			if op+(1+lastLiterals)+(matchLength>>8) > len(dst) {
+				return 0
+			}
+			if matchLength >= mlMask {
+				dst[token] |= mlMask
+				op = writeLength(dst, op, matchLength-mlMask)
+			} else {
+				dst[token] |= byte(matchLength)
+			}
+			anchor = ip
+
+			if ip > mflimit {
+				goto lastLiteral
+			}
+
+			// Fill table
+			table[hashSequence(binary.LittleEndian.Uint32(src[ip-2:]), log)] = int32(ip - 2)
+
+			// Test next position
+			h := hashSequence(binary.LittleEndian.Uint32(src[ip:]), log)
+			ref = int(table[h])
+			table[h] = int32(ip)
+			if ref+maxDistance < ip ||
+				binary.LittleEndian.Uint32(src[ref:]) != binary.LittleEndian.Uint32(src[ip:]) {
+				break
+			}
+			token = op
+			op++
+			dst[token] = 0
+		}
+		ip++
+	}
+
+lastLiteral:
+	lastRun := len(src) - anchor
+	if op+lastRun+1+(lastRun+255-runMask)/255 > len(dst) {
+		return 0
+	}
+	token := op
+	op++
+	if lastRun >= runMask {
+		dst[token] = runMask << mlBits
+		op = writeLength(dst, op, lastRun-runMask)
+	} else {
+		dst[token] = byte(lastRun << mlBits)
+	}
+	op += copy(dst[op:], src[anchor:])
+	return op
+}
+
+// hcMatcher finds matches using hash chains: each hashed position links to
+// the previous position with the same hash.
+type hcMatcher struct {
+	src   []byte
+	next  int
+	depth int
+	head  [1 << hcHashLog]int32
+	chain [1 << 16]uint16
+}
+
+func hashHC(sequence uint32) uint32 {
+	return (sequence * 2654435761) >> (32 - hcHashLog)
+}
+
+// insert adds all positions up to ip to the hash chains.
+func (m *hcMatcher) insert(ip int) {
+	for ; m.next < ip; m.next++ {
+		h := hashHC(binary.LittleEndian.Uint32(m.src[m.next:]))
+		delta := m.next - int(m.head[h]) + 1
+		if m.head[h] == 0 || delta > maxDistance {
+			delta = 0
+		}
+		m.chain[m.next&0xFFFF] = uint16(delta)
+		m.head[h] = int32(m.next + 1)
+	}
+}
+
+// find returns the position and length of the longest match for ip, not
+// extending beyond limit. The length is 0 if there is no match.
+func (m *hcMatcher) find(ip, limit int) (ref, length int) {
+	m.insert(ip)
+	sequence := binary.LittleEndian.Uint32(m.src[ip:])
+	candidate := int(m.head[hashHC(sequence)]) - 1
+	for attempts := m.depth; candidate >= 0 && attempts > 0; attempts-- {
+		if ip-candidate > maxDistance {
+			break
+		}
+		if length == 0 || m.src[candidate+length] == m.src[ip+length] {
+			if binary.LittleEndian.Uint32(m.src[candidate:]) == sequence {
+				n := minMatch
+				for ip+n < limit && m.src[candidate+n] == m.src[ip+n] {
+					n++
+				}
+				if n > length {
+					ref, length = candidate, n
+				}
+			}
+		}
+		delta := int(m.chain[candidate&0xFFFF])
+		if delta == 0 {
+			break
+		}
+		candidate -= delta
+	}
+	return ref, length
+}
+
+// compressBlockHC is like compressBlock but searches the depth most recent
+// candidates for the longest match at each position, and delays a match
+// when the next position has a longer one.
+func compressBlockHC(src, dst []byte, start, depth int) int {
+	m := &hcMatcher{src: src, depth: depth}
+	var (
+		ip, anchor, op = start, start, 0
+		mflimit        = len(src) - mfLimit
+		matchlimit     = len(src) - lastLiterals
+	)
+	for ip <= mflimit {
+		ref, matchLength := m.find(ip, matchlimit)
+		if matchLength == 0 {
+			ip++
+			continue
+		}
+		for ip+1 <= mflimit {
+			ref2, matchLength2 := m.find(ip+1, matchlimit)
+			if matchLength2 <= matchLength {
+				break
+			}
+			ip, ref, matchLength = ip+1, ref2, matchLength2
+		}
+
+		// Catch up
+		for ip > anchor && ref > 0 && src[ip-1] == src[ref-1] {
+			ip--
+			ref--
+			matchLength++
+		}
+
+		litLength := ip - anchor
+		if op+litLength+litLength/255+(1+2+1+lastLiterals)+matchLength/255 > len(dst) {
+			return 0
+		}
+		token := op
+		op++
+		if litLength >= runMask {
+			dst[token] = runMask << mlBits
+			op = writeLength(dst, op, litLength-runMask)
+		} else {
+			dst[token] = byte(litLength << mlBits)
+		}
+		op += copy(dst[op:], src[anchor:ip])
+		binary.LittleEndian.PutUint16(dst[op:], uint16(ip-ref))
+		op += 2
+		if matchLength-minMatch >= mlMask {
+			dst[token] |= mlMask
+			op = writeLength(dst, op, matchLength-minMatch-mlMask)
+		} else {
+			dst[token] |= byte(matchLength - minMatch)
+		}
+		ip += matchLength
+		anchor = ip
+	}
+
+	lastRun := len(src) - anchor
+	if op+lastRun+1+(lastRun+255-runMask)/255 > len(dst) {
+		return 0
+	}
+	token := op
+	op++
+	if lastRun >= runMask {
+		dst[token] = runMask << mlBits
+		op = writeLength(dst, op, lastRun-runMask)
+	} else {
+		dst[token] = byte(lastRun << mlBits)
+	}
+	op += copy(dst[op:], src[anchor:])
+	return op
+}
+
+// writeLength writes the remainder of a literal or match length that did
+// not fit into its token at dst[op:], and returns the new output position.
+func writeLength(dst []byte, op, length int) int {
+	for ; length >= 255; length -= 255 {
+		dst[op] = 255
+		op++
+	}
+	dst[op] = byte(length)
+	return op + 1
+}
+
+// decompressBlock decompresses the LZ4 block src into dst, never writing
+// beyond len(dst), with dict as the data preceding the block. It returns the
+// number of bytes written to dst.
+func decompressBlock(src, dst, dict []byte) (int, error) {
+	var ip, op int
+	for ip < len(src) {
+		token := src[ip]
+		ip++
+
+		// Literals
+		litLength := int(token >> mlBits)
+		if litLength == runMask {
+			for {
+				if ip >= len(src) {
+					return 0, ErrCorrupt
+				}
+				b := src[ip]
+				ip++
+				litLength += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		if litLength > len(src)-ip || litLength > len(dst)-op {
+			return 0, ErrCorrupt
+		}
+		op += copy(dst[op:], src[ip:ip+litLength])
+		ip += litLength
+		if ip == len(src) {
+			// The last sequence only contains literals.
+			return op, nil
+		}
+
+		// Match
+		if ip+2 > len(src) {
+			return 0, ErrCorrupt
+		}
+		offset := int(binary.LittleEndian.Uint16(src[ip:]))
+		ip += 2
+		if offset == 0 {
+			return 0, ErrCorrupt
+		}
+		matchLength := int(token & mlMask)
+		if matchLength == mlMask {
+			for {
+				if ip >= len(src) {
+					return 0, ErrCorrupt
+				}
+				b := src[ip]
+				ip++
+				matchLength += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		matchLength += minMatch
+		if matchLength > len(dst)-op {
+			return 0, ErrCorrupt
+		}
+		ref := op - offset
+		if ref < 0 {
+			// The match starts in the dictionary
+			if -ref > len(dict) {
+				return 0, ErrCorrupt
+			}
+			n := copy(dst[op:op+matchLength], dict[len(dict)+ref:])
+			op += n
+			matchLength -= n
+			ref = 0
+		}
+		if op-ref >= matchLength {
+			op += copy(dst[op:op+matchLength], dst[ref:])
+		} else {
+			// Overlapping copy
+			for i := 0; i < matchLength; i++ {
+				dst[op+i] = dst[ref+i]
+			}
+			op += matchLength
+		}
+	}
+	return 0, ErrCorrupt
+}
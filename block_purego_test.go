@@ -0,0 +1,64 @@
+//go:build !cgo || purego
+// +build !cgo purego
+
+package lz4
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestCompressorFixture(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := ioutil.ReadFile("testdata/pg135.txt.lz4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := new(bytes.Buffer)
+	w, err := NewWriter(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(text); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b.Bytes(), compressed) {
+		t.Errorf("got %d-byte frame want %d-byte frame", b.Len(), len(compressed))
+	}
+}
+
+func TestCompressor(t *testing.T) {
+	for _, tt := range lz4Tests {
+		var opts []Option
+		switch tt.lz4[4] {
+		case 0x64:
+		case 0x74:
+			opts = append(opts, BlockChecksum(true))
+		default:
+			continue
+		}
+		b := new(bytes.Buffer)
+		w, err := NewWriter(b, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(tt.raw)); err != nil {
+			t.Errorf("%s: Write: %v", tt.name, err)
+			continue
+		}
+		if err := w.Close(); err != nil {
+			t.Errorf("%s: Close: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(b.Bytes(), tt.lz4) {
+			t.Errorf("%s: got %x want %x", tt.name, b.Bytes(), tt.lz4)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package lz4
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestCompressBlock(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	random := make([]byte, 64<<10)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	payloads := map[string][]byte{
+		"empty":  {},
+		"short":  []byte("lz4"),
+		"text":   text[:256<<10],
+		"random": random,
+	}
+	for name, payload := range payloads {
+		for _, level := range []int{defaultCompression, BestSpeed, minHCCompression, BestCompression} {
+			dst := make([]byte, CompressBlockBound(len(payload)))
+			n, err := CompressBlockLevel(payload, dst, level)
+			if err != nil {
+				t.Errorf("%s: level %d: %v", name, level, err)
+				continue
+			}
+			b := make([]byte, len(payload))
+			m, err := UncompressBlock(dst[:n], b)
+			if err != nil {
+				t.Errorf("%s: level %d: %v", name, level, err)
+				continue
+			}
+			if !bytes.Equal(b[:m], payload) {
+				t.Errorf("%s: level %d: got %d bytes want %d bytes", name, level, m, len(payload))
+			}
+		}
+	}
+}
+
+func TestCompressBlockShortBuffer(t *testing.T) {
+	random := make([]byte, 4<<10)
+	rand.New(rand.NewSource(1)).Read(random)
+	if _, err := CompressBlock(random, make([]byte, len(random))); err != io.ErrShortBuffer {
+		t.Errorf("got %v want %v", err, io.ErrShortBuffer)
+	}
+	if _, err := CompressBlockLevel(random, nil, BestCompression+1); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestUncompressBlock(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text = text[:64<<10]
+	block := make([]byte, CompressBlockBound(len(text)))
+	n, err := CompressBlock(text, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block = block[:n]
+
+	// The output is limited to the size of dst.
+	if _, err := UncompressBlock(block, make([]byte, len(text)-1)); err == nil {
+		t.Error("expected an error for a short output buffer")
+	}
+	// Truncated or corrupted blocks are rejected.
+	if _, err := UncompressBlock(block[:n/2], make([]byte, len(text))); err == nil {
+		t.Error("expected an error for a truncated block")
+	}
+	if _, err := UncompressBlock(nil, make([]byte, len(text))); err == nil {
+		t.Error("expected an error for an empty block")
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
@@ -14,18 +15,32 @@ import (
 
 var (
 	uncompress = flag.Bool("d", false, "Decompress.")
-	level      = flag.Int("l", 3, "Compression level.")
+	level      = flag.Int("l", 1, "Compression level, from 1 to 12.")
+	fast       = flag.Int("fast", 1, "Acceleration of the fast compression levels.")
+	blockSize  = flag.Int("B", 7, "Block size ID, from 4 (64KB) to 7 (4MB).")
+	blockSum   = flag.Bool("BX", false, "Enable block checksums.")
+	linked     = flag.Bool("BD", false, "Enable block dependency.")
+	threads    = flag.Int("T", 1, "Number of blocks compressed or decompressed concurrently.")
+	noFrameCRC = flag.Bool("no-frame-crc", false, "Disable the content checksum.")
+	dictionary = flag.String("D", "", "Use file as dictionary.")
+	legacy     = flag.Bool("legacy", false, "Use the legacy frame format.")
 	cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
 	memprofile = flag.String("memprofile", "", "write memory profile to this file")
 )
 
-func decompress(path string) error {
+func decompress(path string, dict []byte) error {
 	input, err := os.Open(path)
 	if err != nil {
 		return err
 	}
 	defer input.Close()
-	decompressor, err := lz4.NewReader(input)
+	var decompressor *lz4.Reader
+	concurrency := lz4.ReaderConcurrency(*threads)
+	if dict != nil {
+		decompressor, err = lz4.NewReaderDict(input, 0, dict, concurrency)
+	} else {
+		decompressor, err = lz4.NewReader(input, concurrency)
+	}
 	if err != nil {
 		return err
 	}
@@ -42,30 +57,32 @@ func decompress(path string) error {
 	return nil
 }
 
-func compress(level int, path string) error {
-	if level > lz4.BestCompression {
-		level = lz4.BestCompression
-	} else {
-		level = lz4.BestSpeed
-	}
+func compress(path string, opts []lz4.Option) error {
 	input, err := os.Open(path)
 	if err != nil {
 		return err
 	}
+	info, err := input.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Mode().IsRegular() && !*legacy {
+		opts = append(opts, lz4.ContentSize(uint64(info.Size())))
+	}
 	output, err := os.Create(path + ".lz4")
 	if err != nil {
 		return err
 	}
-	compressor, err := lz4.NewWriterLevel(output, level)
-	defer compressor.Close()
+	compressor, err := lz4.NewWriter(output, opts...)
 	if err != nil {
 		return err
 	}
 	_, err = io.Copy(compressor, input)
 	if err != nil {
+		compressor.Close()
 		return err
 	}
-	return nil
+	return compressor.Close()
 }
 
 func main() {
@@ -89,11 +106,33 @@ func main() {
 		os.Exit(1)
 	}
 
+	var dict []byte
+	if *dictionary != "" {
+		var err error
+		dict, err = ioutil.ReadFile(*dictionary)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	var err error
 	if *uncompress == true {
-		err = decompress(path)
+		err = decompress(path, dict)
 	} else {
-		err = compress(*level, path)
+		opts := []lz4.Option{
+			lz4.BlockSize(1 << (8 + 2*uint(*blockSize))),
+			lz4.BlockChecksum(*blockSum),
+			lz4.BlockIndependence(!*linked),
+			lz4.ContentChecksum(!*noFrameCRC),
+			lz4.Legacy(*legacy),
+			lz4.Acceleration(*fast),
+			lz4.Concurrency(*threads),
+			lz4.Level(*level),
+		}
+		if dict != nil {
+			opts = append(opts, lz4.Dictionary(0, dict))
+		}
+		err = compress(path, opts)
 	}
 	if err != nil {
 		log.Println(err)
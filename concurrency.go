@@ -0,0 +1,185 @@
+package lz4
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Concurrency compresses up to n blocks at the same time, each in its own
+// goroutine, to make use of multiple cores. Blocks are still written in
+// order, and the output is identical to the one of a single goroutine. At
+// most n blocks are held in memory. Linked blocks depend on the previous
+// ones and are always compressed one at a time. The default concurrency is
+// 1. With a concurrency above 1, the writer runs a goroutine until it is
+// closed or reset, so Close must be called.
+func Concurrency(n int) Option {
+	return func(z *Writer) error {
+		if n < 1 {
+			return fmt.Errorf("lz4: invalid concurrency: %d", n)
+		}
+		z.concurrency = n
+		return nil
+	}
+}
+
+// pendingBlock is a block being compressed in its own goroutine.
+type pendingBlock struct {
+	src   []byte
+	dst   []byte
+	block []byte
+	err   error
+	done  chan struct{}
+}
+
+// start starts the goroutine writing the blocks queued by queueBlock.
+func (z *Writer) start() {
+	if z.free == nil {
+		z.free = make(chan *pendingBlock, z.concurrency)
+		for i := 0; i < z.concurrency; i++ {
+			z.free <- new(pendingBlock)
+		}
+	}
+	z.queue = make(chan *pendingBlock, z.concurrency)
+	z.written = make(chan struct{})
+	go z.writeBlocks()
+}
+
+// queueBlock starts compressing a copy of p, waiting for a block to be
+// written first when n blocks are already pending.
+func (z *Writer) queueBlock(p []byte) error {
+	if err := z.writeErr(); err != nil {
+		return err
+	}
+	b := <-z.free
+	if b.src == nil {
+		b.src = make([]byte, z.desc.BlockMaxSize)
+		b.dst = make([]byte, blockBound(z.desc.BlockMaxSize))
+	}
+	b.src = b.src[:copy(b.src[:cap(b.src)], p)]
+	b.done = make(chan struct{})
+	go func() {
+		b.block, b.err = z.encodeBlock(b.dst, b.src, z.dict)
+		close(b.done)
+	}()
+	z.queue <- b
+	return nil
+}
+
+// writeBlocks writes the queued blocks in order as they get compressed.
+// After an error, the remaining blocks are discarded.
+func (z *Writer) writeBlocks() {
+	defer close(z.written)
+	for b := range z.queue {
+		<-b.done
+		err := b.err
+		if err == nil && z.writeErr() == nil {
+			_, err = z.w.Write(b.block)
+		}
+		if err != nil {
+			z.setWriteErr(err)
+		}
+		z.free <- b
+	}
+}
+
+// wait waits for the queued blocks to be written.
+func (z *Writer) wait() error {
+	blocks := make([]*pendingBlock, z.concurrency)
+	for i := range blocks {
+		blocks[i] = <-z.free
+	}
+	for _, b := range blocks {
+		z.free <- b
+	}
+	return z.writeErr()
+}
+
+// stop waits for the queued blocks to be written and stops the goroutine
+// writing them.
+func (z *Writer) stop() error {
+	close(z.queue)
+	<-z.written
+	z.queue = nil
+	return z.writeErr()
+}
+
+func (z *Writer) writeErr() error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.asyncErr
+}
+
+func (z *Writer) setWriteErr(err error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if z.asyncErr == nil {
+		z.asyncErr = err
+	}
+}
+
+// ReaderConcurrency decompresses up to n blocks at the same time, each in its
+// own goroutine, reading the following blocks of the frame while the first
+// ones are decompressed. Data is still returned in order. At most n blocks
+// are read ahead of the data returned by Read. Frames with linked blocks are
+// decompressed one block at a time. The default concurrency is 1.
+func ReaderConcurrency(n int) ReaderOption {
+	return func(z *Reader) error {
+		if n < 1 {
+			return fmt.Errorf("lz4: invalid concurrency: %d", n)
+		}
+		z.concurrency = n
+		return nil
+	}
+}
+
+// nextBlocks decompresses block along with up to n-1 of the blocks following
+// it in the frame, concurrently. Errors are reported after the data of the
+// blocks preceding them.
+func (z *Reader) nextBlocks(block []byte, compressed bool) {
+	type result struct {
+		data   []byte
+		err    error
+		block  int
+		offset int64
+	}
+	var (
+		wg      sync.WaitGroup
+		results = make([]result, z.concurrency)
+		count   int
+		err     error
+	)
+	decode := func(block []byte, compressed bool) {
+		r, b := &results[count], &z.blocks[count]
+		r.block, r.offset = z.block, z.offset
+		count++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.data, r.err = z.decodeBlock(b, block, compressed)
+		}()
+	}
+	decode(block, compressed)
+	for count < z.concurrency {
+		if block, compressed, err = z.readBlock(&z.blocks[count]); err != nil || block == nil {
+			break
+		}
+		decode(block, compressed)
+	}
+	wg.Wait()
+
+	for _, r := range results[:count] {
+		if r.err == nil {
+			r.err = z.addBlock(r.data)
+		}
+		if r.err != nil {
+			z.err = &Error{Frame: z.frame, Block: r.block, Offset: r.offset, Err: r.err}
+			return
+		}
+	}
+	switch {
+	case err != nil:
+		z.err = err
+	case block == nil:
+		z.err = z.nextFrame()
+	}
+}
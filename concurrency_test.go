@@ -0,0 +1,176 @@
+package lz4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestConcurrency(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compress := func(level int, opts ...Option) []byte {
+		buf := new(bytes.Buffer)
+		w, err := NewWriterLevel(buf, level, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Write in chunks not aligned on blocks to exercise buffering.
+		for p := text; len(p) > 0; {
+			n := 100000
+			if n > len(p) {
+				n = len(p)
+			}
+			if _, err := w.Write(p[:n]); err != nil {
+				t.Fatal(err)
+			}
+			p = p[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	for _, tt := range []struct {
+		level int
+		opts  []Option
+	}{
+		{defaultCompression, []Option{BlockSize(Block64KB)}},
+		{defaultCompression, []Option{BlockSize(Block256KB), BlockChecksum(true)}},
+		{defaultCompression, []Option{BlockSize(Block64KB), BlockIndependence(false)}},
+		{minHCCompression, []Option{BlockSize(Block1MB)}},
+		{defaultCompression, []Option{Legacy(true)}},
+	} {
+		want := compress(tt.level, tt.opts...)
+		for _, n := range []int{2, 4, 16} {
+			got := compress(tt.level, append(tt.opts, Concurrency(n))...)
+			if !bytes.Equal(got, want) {
+				t.Errorf("concurrency %d: got %d-byte frame want %d-byte frame", n, len(got), len(want))
+			}
+		}
+	}
+	if _, err := NewWriter(ioutil.Discard, Concurrency(0)); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+type failingWriter struct {
+	n int
+}
+
+var errFailingWriter = errors.New("write failed")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n == 0 {
+		return 0, errFailingWriter
+	}
+	w.n--
+	return len(p), nil
+}
+
+func TestConcurrencyWriteError(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The header takes two writes, the first block one.
+	w, err := NewWriter(&failingWriter{n: 3}, BlockSize(Block64KB), Concurrency(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(text); err != nil && err != errFailingWriter {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != errFailingWriter {
+		t.Errorf("got %v want %v", err, errFailingWriter)
+	}
+}
+
+func TestReaderConcurrency(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range [][]Option{
+		{BlockSize(Block64KB)},
+		{BlockSize(Block64KB), BlockChecksum(true), ContentSize(uint64(len(text)))},
+		{BlockSize(Block64KB), BlockIndependence(false)},
+		{Legacy(true)},
+	} {
+		buf := new(bytes.Buffer)
+		w, err := NewWriter(buf, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(text); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		// Two concatenated frames
+		stream := append(buf.Bytes(), buf.Bytes()...)
+		want := append(text, text...)
+
+		for _, n := range []int{2, 7} {
+			r, err := NewReader(bytes.NewReader(stream), ReaderConcurrency(n))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("concurrency %d: %v", n, err)
+			}
+			if !bytes.Equal(b, want) {
+				t.Errorf("concurrency %d: got %d bytes want %d bytes", n, len(b), len(want))
+			}
+		}
+	}
+	if _, err := NewReader(bytes.NewReader(nil), ReaderConcurrency(0)); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestReaderConcurrencyCorrupted(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text = text[:8*Block64KB]
+	buf := new(bytes.Buffer)
+	w, err := NewWriter(buf, BlockSize(Block64KB), BlockChecksum(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(text); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Corrupt the data of the third block.
+	frame := buf.Bytes()
+	offset := 7
+	for i := 0; i < 2; i++ {
+		offset += 4 + int(binary.LittleEndian.Uint32(frame[offset:])&0x7FFFFFFF) + 4
+	}
+	frame[offset+4+10] ^= 0xFF
+
+	r, err := NewReader(bytes.NewReader(frame), ReaderConcurrency(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	// The blocks preceding the corrupted one are returned.
+	if !bytes.Equal(b, text[:2*Block64KB]) {
+		t.Errorf("got %d bytes want %d bytes", len(b), 2*Block64KB)
+	}
+}
@@ -0,0 +1,80 @@
+package lz4
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+var (
+	dictionariesMu sync.RWMutex
+	dictionaries   = make(map[uint32][]byte)
+)
+
+// RegisterDictionary registers dict under id, so that readers can decode
+// frames declaring that dictionary ID without being given the dictionary
+// explicitly. dict must not be modified after being registered.
+func RegisterDictionary(id uint32, dict []byte) {
+	dictionariesMu.Lock()
+	defer dictionariesMu.Unlock()
+	dictionaries[id] = trimDictionary(dict)
+}
+
+func lookupDictionary(id uint32) ([]byte, bool) {
+	dictionariesMu.RLock()
+	defer dictionariesMu.RUnlock()
+	dict, ok := dictionaries[id]
+	return dict, ok
+}
+
+// trimDictionary returns the last 64KB of dict, the only part blocks can
+// refer to.
+func trimDictionary(dict []byte) []byte {
+	if len(dict) > maxDictSize {
+		return dict[len(dict)-maxDictSize:]
+	}
+	return dict
+}
+
+// Dictionary compresses data using dict, identified in the frame header by
+// id. An id of 0 means the dictionary is not identified in the header, and
+// readers have to be given the dictionary explicitly. dict must not be
+// modified while the writer is in use.
+func Dictionary(id uint32, dict []byte) Option {
+	return func(z *Writer) error {
+		z.desc.DictID = id
+		z.dict = trimDictionary(dict)
+		return nil
+	}
+}
+
+// NewWriterDict is like NewWriter but compresses data using the dictionary
+// dict, identified in the frame header by id, and applies the given options.
+func NewWriterDict(w io.Writer, id uint32, dict []byte, opts ...Option) (*Writer, error) {
+	return NewWriter(w, append([]Option{Dictionary(id, dict)}, opts...)...)
+}
+
+// NewReaderDict is like NewReader but decodes frames using the dictionary
+// dict. Frames declaring a dictionary ID other than id are rejected.
+func NewReaderDict(r io.Reader, id uint32, dict []byte, opts ...ReaderOption) (*Reader, error) {
+	return newReader(&Reader{
+		r:      &countingReader{r: r},
+		dictID: id,
+		dict:   trimDictionary(dict),
+	}, opts)
+}
+
+// dictionary returns the dictionary needed to decode the current frame.
+func (z *Reader) dictionary() ([]byte, error) {
+	if z.desc.DictID == 0 || z.desc.DictID == z.dictID {
+		return z.dict, nil
+	}
+	if z.dict != nil {
+		return nil, fmt.Errorf("%w: dictionary ID %d does not match %d", ErrUnsupported, z.desc.DictID, z.dictID)
+	}
+	dict, ok := lookupDictionary(z.desc.DictID)
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown dictionary %d", ErrUnsupported, z.desc.DictID)
+	}
+	return dict, nil
+}
@@ -0,0 +1,112 @@
+package lz4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+var (
+	testDict   = []byte(`{"level":"info","host":"ingest-01","service":"storage","message":"request completed","status":200}`)
+	testRecord = []byte(`{"level":"info","host":"ingest-02","service":"storage","message":"request completed","status":404}`)
+)
+
+func compressDict(t *testing.T, payload []byte, opts ...Option) []byte {
+	buf := new(bytes.Buffer)
+	w, err := NewWriterDict(buf, 42, testDict, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDictionary(t *testing.T) {
+	var payload []byte
+	for i := 0; i < 1000; i++ {
+		payload = append(payload, fmt.Sprintf("%d", i)...)
+		payload = append(payload, testRecord...)
+	}
+	for _, opts := range [][]Option{
+		nil,
+		{BlockIndependence(false)},
+		{BlockSize(Block64KB)},
+		{BlockSize(Block64KB), BlockIndependence(false)},
+	} {
+		frame := compressDict(t, payload, opts...)
+		if frame[4]&flagDictID == 0 {
+			t.Fatal("dictionary ID flag not set")
+		}
+		if id := binary.LittleEndian.Uint32(frame[6:]); id != 42 {
+			t.Errorf("got dictionary ID %d want 42", id)
+		}
+
+		r, err := NewReaderDict(bytes.NewReader(frame), 42, testDict)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, payload) {
+			t.Errorf("got %d bytes want %d bytes", len(b), len(payload))
+		}
+	}
+}
+
+func TestDictionaryRatio(t *testing.T) {
+	withDict := compressDict(t, testRecord)
+
+	buf := new(bytes.Buffer)
+	w, err := NewWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(testRecord)
+	w.Close()
+
+	if len(withDict) >= buf.Len()-len(testRecord)/2 {
+		t.Errorf("got %d bytes with a dictionary, %d bytes without", len(withDict), buf.Len())
+	}
+}
+
+func TestDictionaryRegistry(t *testing.T) {
+	frame := compressDict(t, testRecord)
+
+	if _, err := NewReader(bytes.NewReader(frame)); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("unknown dictionary: got %v want %v", err, ErrUnsupported)
+	}
+	if _, err := NewReaderDict(bytes.NewReader(frame), 43, testDict); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("mismatched dictionary ID: got %v want %v", err, ErrUnsupported)
+	}
+
+	RegisterDictionary(42, testDict)
+	defer func() {
+		dictionariesMu.Lock()
+		delete(dictionaries, 42)
+		dictionariesMu.Unlock()
+	}()
+	r, err := NewReader(bytes.NewReader(frame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, testRecord) {
+		t.Errorf("got %q want %q", b, testRecord)
+	}
+}
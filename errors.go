@@ -0,0 +1,72 @@
+package lz4
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors reported when reading a stream. More specific errors wrap them, and
+// can be matched using errors.Is.
+var (
+	// ErrInvalidHeader reports data that does not start with a valid frame
+	// header.
+	ErrInvalidHeader = errors.New("lz4: invalid header")
+	// ErrChecksum reports a block or content checksum not matching the data.
+	ErrChecksum = errors.New("lz4: invalid checksum")
+	// ErrCorrupt reports a block that cannot be decompressed, or a frame
+	// whose content does not match its header.
+	ErrCorrupt = errors.New("lz4: data corruption")
+	// ErrUnsupported reports a frame using a feature or format version not
+	// supported by this package.
+	ErrUnsupported = errors.New("lz4: unsupported feature")
+	// ErrBlockTooLarge reports a block larger than the block maximum size of
+	// its frame, or than the limit set by MaxBlockSize.
+	ErrBlockTooLarge = errors.New("lz4: block too large")
+	// ErrOutputLimit reports decompressed data exceeding the limit set by
+	// MaxOutputSize.
+	ErrOutputLimit = errors.New("lz4: decompressed data exceeds the output limit")
+)
+
+// Errors reported when writing a stream.
+var (
+	// ErrContentSize reports data written to a Writer whose size does not
+	// match the one set by ContentSize.
+	ErrContentSize = errors.New("lz4: content size mismatch")
+)
+
+var (
+	errBlockChecksum       = fmt.Errorf("%w: block", ErrChecksum)
+	errContentChecksum     = fmt.Errorf("%w: content", ErrChecksum)
+	errContentSizeCorrupt  = fmt.Errorf("%w: content size mismatch", ErrCorrupt)
+	errContentSizeExceeded = fmt.Errorf("%w: too much data written", ErrContentSize)
+	errContentSizeShort    = fmt.Errorf("%w: too little data written", ErrContentSize)
+	errClosed              = errors.New("lz4: writer closed")
+	errReset               = errors.New("lz4: writer reset")
+)
+
+// An Error is returned by a Reader when the data read is invalid, and
+// records where the problem was detected.
+type Error struct {
+	// Frame is the index of the frame in the stream, from 0, not counting
+	// skippable frames.
+	Frame int
+	// Block is the index of the block in the frame, from 0, or -1 for the
+	// frame header. The end mark of a frame is indexed as one more block.
+	Block int
+	// Offset is the offset in the stream of the frame header or block.
+	Offset int64
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Block < 0 {
+		return fmt.Sprintf("%v (frame %d header at offset %d)", e.Err, e.Frame, e.Offset)
+	}
+	return fmt.Sprintf("%v (frame %d block %d at offset %d)", e.Err, e.Frame, e.Block, e.Offset)
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
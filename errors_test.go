@@ -0,0 +1,154 @@
+package lz4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// blockOffsets returns the offsets of the blocks and end mark of a frame
+// without optional header fields, starting at offset.
+func blockOffsets(frame []byte, offset int, checksums bool) []int {
+	offsets := []int{offset + 7}
+	for i := 7; ; {
+		size := int(binary.LittleEndian.Uint32(frame[i:]) & 0x7FFFFFFF)
+		if size == 0 {
+			return offsets
+		}
+		i += 4 + size
+		if checksums {
+			i += 4
+		}
+		offsets = append(offsets, offset+i)
+	}
+}
+
+func TestErrorHeader(t *testing.T) {
+	frame := lz4Tests[1].lz4
+	for _, tt := range []struct {
+		name   string
+		modify func(b []byte)
+		want   error
+	}{
+		{"magic", func(b []byte) { b[0] ^= 0xFF }, ErrInvalidHeader},
+		{"version", func(b []byte) { b[4] ^= 0xC0 }, ErrUnsupported},
+		{"reserved", func(b []byte) { b[5] |= 1 }, ErrInvalidHeader},
+		{"block size", func(b []byte) { b[5] = 3 << 4 }, ErrUnsupported},
+		{"checksum", func(b []byte) { b[6] ^= 0xFF }, ErrInvalidHeader},
+	} {
+		// The corrupted frame follows a valid one.
+		b := append(append([]byte(nil), frame...), frame...)
+		tt.modify(b[len(frame):])
+		r, err := NewReader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ioutil.ReadAll(r)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v want %v", tt.name, err, tt.want)
+		}
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("%s: got %T want *Error", tt.name, err)
+			continue
+		}
+		if e.Frame != 1 || e.Block != -1 || e.Offset != int64(len(frame)) {
+			t.Errorf("%s: got frame %d block %d offset %d", tt.name, e.Frame, e.Block, e.Offset)
+		}
+	}
+}
+
+func TestErrorBlock(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	w, err := NewWriter(buf, BlockSize(Block64KB), BlockChecksum(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(text[:8*Block64KB]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	frame := buf.Bytes()
+	offsets := blockOffsets(frame, len(frame), true)
+
+	for _, tt := range []struct {
+		name   string
+		modify func(b []byte)
+		block  int
+		want   error
+	}{
+		{"checksum", func(b []byte) { b[offsets[2]+4+10] ^= 0xFF }, 2, ErrChecksum},
+		{"size", func(b []byte) { binary.LittleEndian.PutUint32(b[offsets[5]:], Block64KB+1) }, 5, ErrBlockTooLarge},
+		{"content checksum", func(b []byte) { b[len(b)-1] ^= 0xFF }, 8, ErrChecksum},
+	} {
+		stream := append(append([]byte(nil), frame...), frame...)
+		tt.modify(stream)
+		for _, n := range []int{1, 4} {
+			r, err := NewReader(bytes.NewReader(stream), ReaderConcurrency(n))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = ioutil.ReadAll(r)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("%s: got %v want %v", tt.name, err, tt.want)
+			}
+			var e *Error
+			if !errors.As(err, &e) {
+				t.Errorf("%s: got %T want *Error", tt.name, err)
+				continue
+			}
+			if e.Frame != 1 || e.Block != tt.block || e.Offset != int64(offsets[tt.block]) {
+				t.Errorf("%s: got frame %d block %d offset %d want frame 1 block %d offset %d",
+					tt.name, e.Frame, e.Block, e.Offset, tt.block, offsets[tt.block])
+			}
+		}
+	}
+}
+
+func TestErrorCorrupt(t *testing.T) {
+	// A match offset of 0 in the only block of a frame without checksums.
+	frame := []byte{
+		0x04, 0x22, 0x4d, 0x18, 0x60, 0x70, 0x73,
+		0x08, 0x00, 0x00, 0x00, 0x14, 'a', 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	}
+	r, err := NewReader(bytes.NewReader(frame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(r)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("got %v want %v", err, ErrCorrupt)
+	}
+	if _, err := UncompressBlock(frame[11:19], make([]byte, 64)); err != ErrCorrupt {
+		t.Errorf("UncompressBlock: got %v want %v", err, ErrCorrupt)
+	}
+}
+
+type errorReader struct{}
+
+var errRead = errors.New("read failed")
+
+func (errorReader) Read([]byte) (int, error) {
+	return 0, errRead
+}
+
+func TestErrorReader(t *testing.T) {
+	// Errors of the underlying io.Reader are returned as is.
+	r, err := NewReader(io.MultiReader(bytes.NewReader(lz4Tests[1].lz4[:20]), errorReader{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != errRead {
+		t.Errorf("got %v want %v", err, errRead)
+	}
+}
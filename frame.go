@@ -0,0 +1,190 @@
+package lz4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/vova616/xxhash"
+)
+
+const (
+	lz4SkippableMagic = uint32(0x184D2A50)
+	lz4SkippableMask  = uint32(0xFFFFFFF0)
+)
+
+const (
+	flagDictID            = 1 << 0
+	flagContentChecksum   = 1 << 2
+	flagContentSize       = 1 << 3
+	flagBlockChecksum     = 1 << 4
+	flagBlockIndependence = 1 << 5
+)
+
+// FrameDescriptor describes the content of a frame, as stored in its header.
+type FrameDescriptor struct {
+	// Version is the version of the frame format, currently always 1.
+	Version uint8
+	// BlockIndependence reports whether blocks can be decoded without the
+	// data of the previous blocks.
+	BlockIndependence bool
+	// BlockChecksum reports whether each block is followed by a checksum of
+	// its compressed data.
+	BlockChecksum bool
+	// ContentSize is the size of the uncompressed data, or 0 when unknown.
+	ContentSize uint64
+	// ContentChecksum reports whether the frame ends with a checksum of the
+	// uncompressed data.
+	ContentChecksum bool
+	// DictID identifies the dictionary needed to decode the frame, or 0 when
+	// no dictionary is used.
+	DictID uint32
+	// BlockMaxSize is the maximum size of the uncompressed data of a block.
+	BlockMaxSize int
+}
+
+// legacyDescriptor describes legacy frames, which have no descriptor.
+var legacyDescriptor = FrameDescriptor{
+	BlockIndependence: true,
+	BlockMaxSize:      lz4LegacyBlockSize,
+}
+
+// encode returns the serialized descriptor, followed by its header checksum.
+func (fd *FrameDescriptor) encode() []byte {
+	var flg byte
+	if fd.BlockIndependence {
+		flg |= flagBlockIndependence
+	}
+	if fd.BlockChecksum {
+		flg |= flagBlockChecksum
+	}
+	if fd.ContentSize != 0 {
+		flg |= flagContentSize
+	}
+	if fd.ContentChecksum {
+		flg |= flagContentChecksum
+	}
+	if fd.DictID != 0 {
+		flg |= flagDictID
+	}
+	b := []byte{fd.Version<<6 | flg, byte(blockSizeID(fd.BlockMaxSize) << 4)}
+	if fd.ContentSize != 0 {
+		var size [8]byte
+		binary.LittleEndian.PutUint64(size[:], fd.ContentSize)
+		b = append(b, size[:]...)
+	}
+	if fd.DictID != 0 {
+		var id [4]byte
+		binary.LittleEndian.PutUint32(id[:], fd.DictID)
+		b = append(b, id[:]...)
+	}
+	return append(b, byte(xxhash.Checksum32(b)>>8))
+}
+
+// decode reads a serialized descriptor and its header checksum from r.
+func (fd *FrameDescriptor) decode(r io.Reader) error {
+	b := make([]byte, 2, 15)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return noEOF(err)
+	}
+	flg, bd := b[0], b[1]
+	fd.Version = flg >> 6
+	if fd.Version != 1 {
+		return fmt.Errorf("%w: frame version %d", ErrUnsupported, fd.Version)
+	}
+	if flg&(1<<1) != 0 || bd&0x8F != 0 {
+		return fmt.Errorf("%w: reserved bits set", ErrInvalidHeader)
+	}
+	id := uint32(bd>>4) & 0x7
+	if id < 4 {
+		return fmt.Errorf("%w: block size ID %d", ErrUnsupported, id)
+	}
+	fd.BlockMaxSize = int(blockSize(id))
+	fd.BlockIndependence = flg&flagBlockIndependence != 0
+	fd.BlockChecksum = flg&flagBlockChecksum != 0
+	fd.ContentChecksum = flg&flagContentChecksum != 0
+
+	// Read the optional fields and the header checksum
+	n := 1
+	if flg&flagContentSize != 0 {
+		n += 8
+	}
+	if flg&flagDictID != 0 {
+		n += 4
+	}
+	b = b[:2+n]
+	if _, err := io.ReadFull(r, b[2:]); err != nil {
+		return noEOF(err)
+	}
+	fields, checksum := b[2:len(b)-1], b[len(b)-1]
+	fd.ContentSize = 0
+	if flg&flagContentSize != 0 {
+		fd.ContentSize = binary.LittleEndian.Uint64(fields)
+		fields = fields[8:]
+	}
+	fd.DictID = 0
+	if flg&flagDictID != 0 {
+		fd.DictID = binary.LittleEndian.Uint32(fields)
+	}
+	if checksum != byte(xxhash.Checksum32(b[:len(b)-1])>>8) {
+		return fmt.Errorf("%w: descriptor checksum mismatch", ErrInvalidHeader)
+	}
+	return nil
+}
+
+// ReadFrameInfo reads the header of the first frame of r, skipping skippable
+// frames, and returns its descriptor. r is left positioned at the first block
+// of the frame. Legacy frames are described with a Version of 0. Unlike
+// NewReader, ReadFrameInfo does not need the dictionary of the frame.
+func ReadFrameInfo(r io.Reader) (FrameDescriptor, error) {
+	var (
+		fd FrameDescriptor
+		b  [4]byte
+	)
+	for {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return fd, err
+		}
+		magic := binary.LittleEndian.Uint32(b[:])
+		switch {
+		case magic&lz4SkippableMask == lz4SkippableMagic:
+			if _, err := io.ReadFull(r, b[:]); err != nil {
+				return fd, noEOF(err)
+			}
+			size := int64(binary.LittleEndian.Uint32(b[:]))
+			if _, err := io.CopyN(ioutil.Discard, r, size); err != nil {
+				return fd, noEOF(err)
+			}
+		case magic == lz4Magic:
+			err := fd.decode(r)
+			return fd, err
+		case magic == lz4LegacyMagic:
+			return legacyDescriptor, nil
+		default:
+			return fd, ErrInvalidHeader
+		}
+	}
+}
+
+// WriteSkippableFrame writes a skippable frame holding data to w. Readers
+// ignore skippable frames, which can therefore embed user metadata in a
+// stream. nibble selects one of the 16 skippable magic numbers. It must be
+// called between frames, before or after a compressed frame is written.
+func WriteSkippableFrame(w io.Writer, nibble uint8, data []byte) error {
+	if nibble > 0xF {
+		return errors.New("lz4: invalid skippable frame nibble")
+	}
+	if uint64(len(data)) > 0xFFFFFFFF {
+		return errors.New("lz4: skippable frame too large")
+	}
+	header := make([]byte, 8)
+	binary.LittleEndian.PutUint32(header, lz4SkippableMagic|uint32(nibble))
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(data)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
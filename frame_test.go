@@ -0,0 +1,145 @@
+package lz4
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestFrameDescriptor(t *testing.T) {
+	for _, fd := range []FrameDescriptor{
+		{Version: 1, BlockIndependence: true, ContentChecksum: true, BlockMaxSize: Block4MB},
+		{Version: 1, BlockChecksum: true, BlockMaxSize: Block64KB},
+		{Version: 1, ContentSize: 1 << 40, BlockMaxSize: Block256KB},
+		{Version: 1, BlockIndependence: true, DictID: 0xdeadbeef, BlockMaxSize: Block1MB},
+		{Version: 1, ContentSize: 12, ContentChecksum: true, DictID: 1, BlockMaxSize: Block4MB},
+	} {
+		var got FrameDescriptor
+		if err := got.decode(bytes.NewReader(fd.encode())); err != nil {
+			t.Errorf("%+v: %v", fd, err)
+			continue
+		}
+		if got != fd {
+			t.Errorf("got %+v want %+v", got, fd)
+		}
+	}
+}
+
+func TestFrameDescriptorEncode(t *testing.T) {
+	fd := FrameDescriptor{Version: 1, BlockIndependence: true, ContentChecksum: true, BlockMaxSize: Block4MB}
+	if got, want := fd.encode(), []byte{0x64, 0x70, 0xb9}; !bytes.Equal(got, want) {
+		t.Errorf("got %#x want %#x", got, want)
+	}
+}
+
+func TestFrameDescriptorChecksum(t *testing.T) {
+	var fd FrameDescriptor
+	if err := fd.decode(bytes.NewReader([]byte{0x64, 0x70, 0xb8})); err == nil {
+		t.Error("expected a header checksum error")
+	}
+}
+
+func TestSkippableFrame(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := WriteSkippableFrame(buf, 0x0, []byte("schema=2")); err != nil {
+		t.Fatal(err)
+	}
+	buf.Write(lz4Tests[1].lz4)
+	if err := WriteSkippableFrame(buf, 0xF, []byte("host=ingest-01")); err != nil {
+		t.Fatal(err)
+	}
+	buf.Write(lz4Tests[2].lz4)
+	stream := buf.Bytes()
+
+	var metadata []string
+	r, err := NewReader(bytes.NewReader(stream), SkippableFrameHandler(func(nibble uint8, data []byte) error {
+		metadata = append(metadata, fmt.Sprintf("%x:%s", nibble, data))
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := lz4Tests[1].raw + lz4Tests[2].raw; string(b) != want {
+		t.Errorf("got %q want %q", b, want)
+	}
+	if got, want := strings.Join(metadata, ","), "0:schema=2,f:host=ingest-01"; got != want {
+		t.Errorf("got metadata %q want %q", got, want)
+	}
+
+	// Without a handler, skippable frames are ignored
+	r, err = NewReader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(r); err != nil || len(b) != len(lz4Tests[1].raw)+len(lz4Tests[2].raw) {
+		t.Errorf("got %d bytes, %v", len(b), err)
+	}
+
+	errStop := errors.New("stop")
+	_, err = NewReader(bytes.NewReader(stream), SkippableFrameHandler(func(uint8, []byte) error {
+		return errStop
+	}))
+	if !errors.Is(err, errStop) {
+		t.Errorf("got %v want %v", err, errStop)
+	}
+}
+
+func TestSkippableFrameNibble(t *testing.T) {
+	if err := WriteSkippableFrame(ioutil.Discard, 0x10, nil); err == nil {
+		t.Error("expected an error for an invalid nibble")
+	}
+}
+
+func TestReadFrameInfo(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteSkippableFrame(&b, 1, []byte("metadata")); err != nil {
+		t.Fatal(err)
+	}
+	want := FrameDescriptor{Version: 1, BlockChecksum: true, ContentSize: 5, DictID: 42, BlockMaxSize: Block256KB}
+	w, err := NewWriterDict(&b, 42, []byte("dictionary"), BlockSize(Block256KB), BlockChecksum(true),
+		ContentChecksum(false), BlockIndependence(false), ContentSize(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(b.Bytes())
+	got, err := ReadFrameInfo(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("got %+v want %+v", got, want)
+	}
+	// r is left at the first block.
+	if size := r.Size() - int64(r.Len()); size != 16+4+2+8+4+1 {
+		t.Errorf("read %d bytes", size)
+	}
+
+	legacy, err := ioutil.ReadFile("testdata/legacy.lz4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ReadFrameInfo(bytes.NewReader(legacy)); err != nil || got.Version != 0 || got.BlockMaxSize != 8<<20 {
+		t.Errorf("legacy: got %+v, %v", got, err)
+	}
+
+	if _, err := ReadFrameInfo(strings.NewReader("invalid header")); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("got %v want %v", err, ErrInvalidHeader)
+	}
+	if _, err := ReadFrameInfo(bytes.NewReader(b.Bytes()[:20])); err != io.ErrUnexpectedEOF {
+		t.Errorf("got %v want %v", err, io.ErrUnexpectedEOF)
+	}
+}
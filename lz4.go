@@ -1,365 +1,950 @@
 package lz4
 
-/*
-#cgo LDFLAGS: -llz4
-#cgo CFLAGS: -O3
-#include "lz4.h"
-#include "lz4hc.h"
-*/
-import "C"
-
 import (
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"hash"
 	"io"
-	"unsafe"
+	"io/ioutil"
+	"sync"
 
 	"github.com/vova616/xxhash"
 )
 
+// Block maximum sizes supported by the frame format. Block4MB is the
+// default.
+const (
+	Block64KB  = 64 << 10
+	Block256KB = 256 << 10
+	Block1MB   = 1 << 20
+	Block4MB   = 4 << 20
+)
+
 const (
 	// BestSpeed provides speed over better compression.
-	BestSpeed = 3
+	BestSpeed = 1
 	// BestCompression provides better compression over speed.
-	BestCompression    = 9
+	BestCompression = 12
+	// Levels from minHCCompression use the high compression compressor,
+	// lower levels use the fast one, like the reference implementation.
+	minHCCompression   = 3
 	defaultCompression = -1
 	lz4EOM             = uint32(0)
 	lz4Magic           = uint32(0x184D2204)
+	lz4LegacyMagic     = uint32(0x184C2102)
+	lz4LegacyBlockSize = 8 << 20
 	lz4BlockSizeID     = 7
 	lz4BlockSize       = 1 << (8 + (2 * lz4BlockSizeID))
+	maxDictSize        = 64 << 10
 )
 
-var lz4Header = []byte{
-	0x4, 0x22, 0x4d, 0x18, 0x64, 0x70, 0xb9,
+// compressBound returns the maximum size of the compressed form of n bytes.
+func compressBound(n int) int {
+	return n + n/255 + 16
+}
+
+// blockBound returns the maximum size of a block holding n bytes, including
+// its size and checksum.
+func blockBound(n int) int {
+	return 4 + compressBound(n) + 4
 }
 
 func blockSize(blockID uint32) uint32 {
 	return (1 << (8 + (2 * blockID)))
 }
 
-type writer struct {
-	level      int
-	err        error
-	compressor func(src []byte, dst []byte, maxSize uint32) (int, error)
+// blockSizeID returns the block maximum size ID of size, or 0 if size is not
+// a valid block maximum size.
+func blockSizeID(size int) uint32 {
+	for id := uint32(4); id <= 7; id++ {
+		if blockSize(id) == uint32(size) {
+			return id
+		}
+	}
+	return 0
+}
+
+// Option configures a Writer.
+type Option func(*Writer) error
 
-	h hash.Hash32
-	w io.Writer
+// Level sets the compression level: BestSpeed, BestCompression or any level
+// in between. Levels from 3 use the high compression compressor, trading
+// speed for a better compression ratio.
+func Level(level int) Option {
+	return func(z *Writer) error {
+		if level < defaultCompression || level > BestCompression {
+			return fmt.Errorf("lz4: invalid compression level: %d", level)
+		}
+		z.level = level
+		return nil
+	}
 }
 
-// NewWriter creates a new Writer that satisfies writes by compressing data
-// written to w.
-func NewWriter(w io.Writer) io.WriteCloser {
-	z, _ := NewWriterLevel(w, defaultCompression)
-	return z
+// BlockSize sets the maximum size of the blocks written, which must be one
+// of Block64KB, Block256KB, Block1MB or Block4MB. Readers need to allocate
+// buffers of that size to decode the frame.
+func BlockSize(size int) Option {
+	return func(z *Writer) error {
+		if blockSizeID(size) == 0 {
+			return fmt.Errorf("lz4: invalid block size: %d", size)
+		}
+		z.desc.BlockMaxSize = size
+		return nil
+	}
 }
 
-// NewWriterLevel is like NewWriter but specifies the compression level instead
-// of assuming the default compression level.
-func NewWriterLevel(w io.Writer, level int) (io.WriteCloser, error) {
-	if level < defaultCompression || level > BestCompression {
-		return nil, fmt.Errorf("lz4: invalid compression level: %d", level)
+// BlockChecksum enables or disables the checksum written after each block,
+// which lets readers detect corruption at block granularity.
+func BlockChecksum(enabled bool) Option {
+	return func(z *Writer) error {
+		z.desc.BlockChecksum = enabled
+		return nil
 	}
-	return &writer{
-		level: level,
-		w:     w,
-		h:     xxhash.New(0),
-	}, nil
 }
 
-func (z *writer) writeHeader() error {
-	// Write magic number and header
-	if _, err := z.w.Write(lz4Header); err != nil {
-		return err
+// ContentChecksum enables or disables the checksum of the uncompressed data
+// written at the end of the frame. It is enabled by default; disabling it
+// saves hashing all the data written.
+func ContentChecksum(enabled bool) Option {
+	return func(z *Writer) error {
+		z.desc.ContentChecksum = enabled
+		return nil
 	}
-	return nil
 }
 
-func (z *writer) write(v interface{}) error {
-	return binary.Write(z.w, binary.LittleEndian, v)
+// BlockIndependence enables or disables independent blocks. Dependent, or
+// linked, blocks can refer to the data of the previous blocks, which improves
+// the compression ratio of small blocks, but must be decoded sequentially.
+func BlockIndependence(enabled bool) Option {
+	return func(z *Writer) error {
+		z.desc.BlockIndependence = enabled
+		return nil
+	}
 }
 
-// Write writes a compressed form of p to the underlying io.Writer.
-func (z *writer) Write(p []byte) (int, error) {
-	if z.err != nil {
-		return 0, z.err
+// Legacy writes the legacy frame format, as used by the Linux kernel, instead
+// of the current one. Legacy frames have no header and are made of 8MB
+// blocks; block checksums, content size, dictionaries and linked blocks are
+// not supported, and no content checksum is written.
+func Legacy(enabled bool) Option {
+	return func(z *Writer) error {
+		z.legacy = enabled
+		return nil
+	}
+}
+
+// ContentSize declares the size of the uncompressed data in the frame
+// header. Writing more or less data than declared is an error. A size of 0
+// means the size is unknown and is not written.
+func ContentSize(size uint64) Option {
+	return func(z *Writer) error {
+		z.desc.ContentSize = size
+		return nil
+	}
+}
+
+// Acceleration trades compression ratio for speed with the fast compression
+// levels, below 3, like the --fast option of the reference implementation.
+// The default acceleration is 1; each increment speeds up compression by
+// skipping more of the data where no match is found. It has no effect on the
+// high compression levels.
+func Acceleration(n int) Option {
+	return func(z *Writer) error {
+		if n < 1 {
+			return fmt.Errorf("lz4: invalid acceleration: %d", n)
+		}
+		z.acceleration = n
+		return nil
+	}
+}
+
+// A Writer is an io.WriteCloser. Writes to a Writer are compressed and
+// written to the underlying io.Writer as an lz4 frame.
+type Writer struct {
+	level        int
+	acceleration int
+	concurrency  int
+	legacy       bool
+	started      bool
+	closed       bool
+	desc         FrameDescriptor
+	err          error
+	compressor   func(src []byte, dst []byte, dict []byte) (int, error)
+
+	n          uint64
+	buf        []byte
+	compressed []byte
+	dict       []byte
+	hist       []byte
+	h          hash.Hash32
+	w          io.Writer
+
+	// Blocks compressed concurrently
+	free     chan *pendingBlock
+	queue    chan *pendingBlock
+	written  chan struct{}
+	mu       sync.Mutex
+	asyncErr error
+}
+
+// NewWriter creates a new Writer that satisfies writes by compressing data
+// written to w, configured by the given options. Invalid options and
+// combinations of options are reported before anything is written.
+func NewWriter(w io.Writer, opts ...Option) (*Writer, error) {
+	z := &Writer{
+		level:        defaultCompression,
+		acceleration: 1,
+		desc: FrameDescriptor{
+			Version:           1,
+			BlockIndependence: true,
+			ContentChecksum:   true,
+			BlockMaxSize:      lz4BlockSize,
+		},
+		w: w,
+		h: xxhash.New(0),
+	}
+	for _, opt := range opts {
+		if err := opt(z); err != nil {
+			return nil, err
+		}
+	}
+	if z.legacy {
+		if z.desc.BlockChecksum || z.desc.ContentSize != 0 || z.dict != nil || !z.desc.BlockIndependence {
+			return nil, fmt.Errorf("%w: option not available with legacy frames", ErrUnsupported)
+		}
+		z.desc.ContentChecksum = false
+		z.desc.BlockMaxSize = lz4LegacyBlockSize
+	}
+	return z, nil
+}
+
+// NewWriterLevel is like NewWriter but specifies the compression level, as
+// the Level option does.
+func NewWriterLevel(w io.Writer, level int, opts ...Option) (*Writer, error) {
+	return NewWriter(w, append([]Option{Level(level)}, opts...)...)
+}
+
+// appendHistory appends p to hist, only keeping the last 64KB that following
+// dependent blocks can refer to.
+func appendHistory(hist, p []byte) []byte {
+	if len(p) >= maxDictSize {
+		return append(hist[:0], p[len(p)-maxDictSize:]...)
 	}
-	// Write headers
+	if n := len(hist) + len(p) - maxDictSize; n > 0 {
+		hist = hist[:copy(hist, hist[n:])]
+	}
+	return append(hist, p...)
+}
+
+// init allocates the block buffers and writes the frame header the first
+// time the writer is used.
+func (z *Writer) init() error {
+	if z.started {
+		return nil
+	}
+	z.started = true
 	if z.compressor == nil {
-		if z.level == BestCompression {
-			z.compressor = lz4CompressBest
+		if level := z.level; level >= minHCCompression {
+			z.compressor = func(src, dst, dict []byte) (int, error) {
+				return lz4CompressHC(src, dst, dict, level)
+			}
 		} else {
-			z.compressor = lz4CompressSpeed
+			acceleration := z.acceleration
+			z.compressor = func(src, dst, dict []byte) (int, error) {
+				return lz4CompressSpeed(src, dst, dict, acceleration)
+			}
 		}
-		z.err = z.writeHeader()
-		if z.err != nil {
-			return 0, z.err
+		z.buf = make([]byte, 0, z.desc.BlockMaxSize)
+		z.compressed = make([]byte, blockBound(z.desc.BlockMaxSize))
+	}
+	if !z.desc.BlockIndependence {
+		z.hist = appendHistory(z.hist[:0], z.dict)
+	}
+	if err := z.writeHeader(); err != nil {
+		return err
+	}
+	// Linked blocks are compressed in order.
+	if z.concurrency > 1 && z.desc.BlockIndependence {
+		z.start()
+	}
+	return nil
+}
+
+func (z *Writer) writeHeader() error {
+	if z.legacy {
+		return z.write(lz4LegacyMagic)
+	}
+	// Write magic number and frame descriptor
+	if err := z.write(lz4Magic); err != nil {
+		return err
+	}
+	_, err := z.w.Write(z.desc.encode())
+	return err
+}
+
+func (z *Writer) write(v interface{}) error {
+	return binary.Write(z.w, binary.LittleEndian, v)
+}
+
+// encodeBlock compresses p into dst and returns the block as stored in the
+// frame: its size, its data, stored uncompressed when compression would not
+// make it smaller, and its checksum. dst must hold blockBound(len(p)) bytes.
+func (z *Writer) encodeBlock(dst, p, dict []byte) ([]byte, error) {
+	if z.legacy {
+		// Legacy blocks are always compressed
+		n, err := z.compressor(p, dst[4:], nil)
+		if err != nil {
+			return nil, err
 		}
+		binary.LittleEndian.PutUint32(dst, uint32(n))
+		return dst[:4+n], nil
+	}
+	n, err := z.compressor(p, dst[4:4+len(p)-1], dict)
+	if err != nil {
+		return nil, err
+	}
+	size := uint32(n)
+	if n == 0 {
+		n = copy(dst[4:], p)
+		size = uint32(n) | 0x80000000
+	}
+	binary.LittleEndian.PutUint32(dst, size)
+	block := dst[:4+n]
+	if z.desc.BlockChecksum {
+		block = dst[:4+n+4]
+		binary.LittleEndian.PutUint32(block[4+n:], xxhash.Checksum32(dst[4:4+n]))
 	}
+	return block, nil
+}
 
-	compressed := make([]byte, lz4BlockSize)
-	n, err := z.compressor(p, compressed, lz4BlockSize)
+// writeBlock compresses p and writes it as a single block, or queues it when
+// blocks are compressed concurrently.
+func (z *Writer) writeBlock(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	if z.queue != nil {
+		return z.queueBlock(p)
+	}
+	dict := z.hist
+	if z.desc.BlockIndependence {
+		dict = z.dict
+	}
+	block, err := z.encodeBlock(z.compressed, p, dict)
 	if err != nil {
-		z.err = err
+		return err
+	}
+	if !z.desc.BlockIndependence {
+		z.hist = appendHistory(z.hist, p)
+	}
+	_, err = z.w.Write(block)
+	return err
+}
+
+// Write writes a compressed form of p to the underlying io.Writer. Data is
+// buffered until a full block is available or the writer is closed.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if z.closed {
+		return 0, errClosed
+	}
+	if z.err = z.init(); z.err != nil {
 		return 0, z.err
 	}
 
-	if n > 0 {
-		z.err = z.write(uint32(n))
-		if z.err != nil {
-			return 0, z.err
-		}
-		// Write compressed block
-		_, z.err = z.w.Write(compressed[0:n])
-		if z.err != nil {
-			return 0, z.err
-		}
-	} else {
-		z.err = z.write(uint32(len(p)) | 0x80000000)
-		if z.err != nil {
-			return 0, z.err
+	n := len(p)
+	if z.desc.ContentSize != 0 && z.n+uint64(n) > z.desc.ContentSize {
+		z.err = errContentSizeExceeded
+		return 0, z.err
+	}
+	z.n += uint64(n)
+	if z.desc.ContentChecksum && n > 0 {
+		z.h.Write(p)
+	}
+	for len(p) > 0 {
+		// Compress full blocks straight from p when nothing is buffered.
+		if len(z.buf) == 0 && len(p) >= z.desc.BlockMaxSize {
+			if z.err = z.writeBlock(p[:z.desc.BlockMaxSize]); z.err != nil {
+				return 0, z.err
+			}
+			p = p[z.desc.BlockMaxSize:]
+			continue
 		}
-		// Write uncompressed block
-		_, z.err = z.w.Write(p)
-		if z.err != nil {
-			return 0, z.err
+		m := copy(z.buf[len(z.buf):z.desc.BlockMaxSize], p)
+		z.buf = z.buf[:len(z.buf)+m]
+		p = p[m:]
+		if len(z.buf) == z.desc.BlockMaxSize {
+			if z.err = z.writeBlock(z.buf); z.err != nil {
+				return 0, z.err
+			}
+			z.buf = z.buf[:0]
 		}
 	}
+	return n, nil
+}
 
-	if len(p) > 0 {
-		z.h.Write(p)
+// Flush compresses and writes any buffered data as a block, so that readers
+// can decode all the data written so far. The frame is left open: unlike
+// Close, no end mark or content checksum is written. Flushing often hurts
+// the compression ratio, as blocks get smaller.
+func (z *Writer) Flush() error {
+	if z.err != nil {
+		return z.err
 	}
-
-	return len(p), nil
+	if z.closed {
+		return errClosed
+	}
+	if z.err = z.init(); z.err != nil {
+		return z.err
+	}
+	if z.err = z.writeBlock(z.buf); z.err != nil {
+		return z.err
+	}
+	z.buf = z.buf[:0]
+	if z.queue != nil {
+		z.err = z.wait()
+	}
+	return z.err
 }
 
-// Close closes the Writer. It does not close the underlying io.Writer.
-func (z *writer) Close() error {
+// Close flushes any pending data and closes the Writer. It does not close
+// the underlying io.Writer. Closing the Writer again returns the result of
+// the first Close, and writing to it afterwards returns an error.
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.err
+	}
+	z.closed = true
+	if z.err == nil {
+		z.err = z.init()
+	}
+	if z.err == nil && z.desc.ContentSize != 0 && z.n != z.desc.ContentSize {
+		z.err = errContentSizeShort
+	}
+	if z.err == nil {
+		z.err = z.writeBlock(z.buf)
+		z.buf = z.buf[:0]
+	}
+	if z.queue != nil {
+		// Wait for the queued blocks, even after an error, to release the
+		// goroutine writing them.
+		if err := z.stop(); z.err == nil {
+			z.err = err
+		}
+	}
+	if z.err != nil || z.legacy {
+		return z.err
+	}
 	z.err = z.write(uint32(0))
-	if z.err != nil {
+	if z.err != nil || !z.desc.ContentChecksum {
 		return z.err
 	}
-	return z.write(z.h.Sum32())
+	z.err = z.write(z.h.Sum32())
+	return z.err
+}
+
+// Reset discards the writer's state and makes it equivalent to the result of
+// NewWriterLevel with the same level and options, but writing to w instead.
+// This permits reusing a writer and its buffers rather than allocating a new
+// one. Pending data is discarded.
+func (z *Writer) Reset(w io.Writer) {
+	if z.queue != nil {
+		// Discard the queued blocks
+		z.setWriteErr(errReset)
+		z.stop()
+		z.asyncErr = nil
+	}
+	z.w = w
+	z.err = nil
+	z.started = false
+	z.closed = false
+	z.n = 0
+	z.buf = z.buf[:0]
+	z.hist = z.hist[:0]
+	z.h.Reset()
+}
+
+// ReaderOption configures a Reader.
+type ReaderOption func(*Reader) error
+
+// SingleFrame stops the reader at the end of the first frame instead of
+// continuing with the frames that follow it, leaving the underlying
+// io.Reader positioned right after the frame. Legacy frames have no end mark,
+// so the magic number of the frame following them is consumed.
+func SingleFrame(enabled bool) ReaderOption {
+	return func(z *Reader) error {
+		z.singleFrame = enabled
+		return nil
+	}
 }
 
-func lz4CompressSpeed(src []byte, dst []byte, maxSize uint32) (int, error) {
-	if len(src) == 0 {
-		return 0, nil
+// SkippableFrameHandler calls fn with the magic number nibble and the data
+// of each skippable frame found in the stream. Returning an error from fn
+// stops the reader with an *Error wrapping it.
+func SkippableFrameHandler(fn func(nibble uint8, data []byte) error) ReaderOption {
+	return func(z *Reader) error {
+		z.skippable = fn
+		return nil
+	}
+}
+
+// VerifyChecksums enables or disables the verification of the block and
+// content checksums of frames, which is enabled by default. Disabling it
+// saves hashing all the data read from trusted sources. Frame headers are
+// always verified.
+func VerifyChecksums(enabled bool) ReaderOption {
+	return func(z *Reader) error {
+		z.skipChecksums = !enabled
+		return nil
 	}
-	n := C.LZ4_compress_limitedOutput((*C.char)(unsafe.Pointer(&src[0])), (*C.char)(unsafe.Pointer(&dst[0])), C.int(len(src)), C.int(maxSize))
-	if n <= 0 {
-		return 0, errors.New("lz4: data corruption")
+}
+
+// MaxBlockSize rejects frames whose blocks can be larger than size bytes
+// once decompressed, bounding the memory allocated for each block. Legacy
+// frames are made of 8MB blocks.
+func MaxBlockSize(size int) ReaderOption {
+	return func(z *Reader) error {
+		if size <= 0 {
+			return fmt.Errorf("lz4: invalid maximum block size: %d", size)
+		}
+		z.maxBlockSize = size
+		return nil
 	}
-	return int(n), nil
 }
 
-func lz4CompressBest(src []byte, dst []byte, maxSize uint32) (int, error) {
-	n := C.LZ4_compressHC_limitedOutput((*C.char)(unsafe.Pointer(&src[0])), (*C.char)(unsafe.Pointer(&dst[0])), C.int(len(src)), C.int(maxSize))
-	if n <= 0 {
-		return 0, errors.New("lz4: data corruption")
+// MaxOutputSize stops the reader with ErrOutputLimit when the decompressed
+// data of the stream, including all its frames, would exceed size bytes. It
+// protects against small streams decompressing to huge amounts of data.
+func MaxOutputSize(size int64) ReaderOption {
+	return func(z *Reader) error {
+		if size <= 0 {
+			return fmt.Errorf("lz4: invalid maximum output size: %d", size)
+		}
+		z.maxOutput = size
+		return nil
 	}
-	return int(n), nil
 }
 
-type reader struct {
-	maxBlockSize        uint32
-	contentChecksumFlag bool
-	blockChecksumFlag   bool
+// A Reader is an io.Reader that can be read to retrieve uncompressed data
+// from a stream of lz4 frames.
+type Reader struct {
+	singleFrame bool
+	skippable   func(nibble uint8, data []byte) error
+	concurrency int
+
+	skipChecksums bool
+	maxBlockSize  int
+	maxOutput     int64
+	total         int64
+
+	legacy    bool
+	desc      FrameDescriptor
+	n         uint64
+	dictID    uint32
+	dict      []byte
+	frameDict []byte
+	hist      []byte
+
+	// Position of the frame header or block being read, for errors
+	frame  int
+	block  int
+	offset int64
+
+	blocks  []blockBuffer
+	buf     []byte
+	off     int
+	scratch [4]byte
+	r       *countingReader
+	h       hash.Hash32
+	err     error
+}
 
-	buf []byte
+// countingReader counts the bytes read from r, and records the last error
+// returned by r.
+type countingReader struct {
 	r   io.Reader
-	h   hash.Hash32
+	n   int64
 	err error
 }
 
-// NewReader creates a new Reader reading the given reader.
-func NewReader(r io.Reader) (io.ReadCloser, error) {
-	z := &reader{
-		r: r,
-		h: xxhash.New(0),
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if err != nil {
+		c.err = err
 	}
+	return n, err
+}
+
+// blockBuffer holds the buffers used to read and decompress a block, which
+// are reused for the following blocks.
+type blockBuffer struct {
+	block []byte
+	data  []byte
+}
+
+// resize returns b resized to n bytes, allocating a new slice if b is too
+// small.
+func resize(b []byte, n int) []byte {
+	if cap(b) < n {
+		return make([]byte, n)
+	}
+	return b[:n]
+}
+
+// NewReader creates a new Reader reading the given reader. Concatenated
+// frames are decoded one after the other, and frames declaring a dictionary
+// ID are decoded using the dictionary registered under that ID.
+func NewReader(r io.Reader, opts ...ReaderOption) (*Reader, error) {
+	return newReader(&Reader{r: &countingReader{r: r}}, opts)
+}
+
+func newReader(z *Reader, opts []ReaderOption) (*Reader, error) {
+	for _, opt := range opts {
+		if err := opt(z); err != nil {
+			return nil, err
+		}
+	}
+	z.h = xxhash.New(0)
+	n := z.concurrency
+	if n < 1 {
+		n = 1
+	}
+	z.blocks = make([]blockBuffer, n)
+	z.frame = -1
 	if err := z.readFrame(); err != nil {
-		return nil, err
+		return nil, z.wrapError(err)
 	}
 	return z, nil
 }
 
-func (z *reader) readFrame() error {
-	// Read and check magic
+// Reset discards the reader's state and makes it equivalent to the result of
+// NewReader with the same options, but reading from r instead. This permits
+// reusing a reader and its buffers rather than allocating a new one.
+func (z *Reader) Reset(r io.Reader) error {
+	*z.r = countingReader{r: r}
+	z.buf = z.buf[:0]
+	z.off = 0
+	z.total = 0
+	z.frame = -1
+	z.err = z.wrapError(z.readFrame())
+	return z.err
+}
+
+// wrapError returns err as an *Error locating where it was detected, unless
+// it is io.EOF or was returned by the underlying io.Reader.
+func (z *Reader) wrapError(err error) error {
+	if err == nil || err == io.EOF || err == z.r.err {
+		return err
+	}
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	return &Error{Frame: z.frame, Block: z.block, Offset: z.offset, Err: err}
+}
+
+func (z *Reader) readFrame() error {
 	var magic uint32
 	if err := z.read(&magic); err != nil {
 		return err
 	}
-	if magic != lz4Magic {
-		return errors.New("lz4: invalid header")
+	return z.startFrame(magic)
+}
+
+// startFrame reads the frame starting with magic, skipping skippable frames.
+func (z *Reader) startFrame(magic uint32) error {
+	z.frame++
+	z.block = -1
+	z.offset = z.r.n - 4
+	for magic&lz4SkippableMask == lz4SkippableMagic {
+		if err := z.skipFrame(uint8(magic &^ lz4SkippableMask)); err != nil {
+			return err
+		}
+		if err := z.read(&magic); err != nil {
+			return err
+		}
+		z.offset = z.r.n - 4
+	}
+	var dict []byte
+	switch magic {
+	case lz4Magic:
+		z.legacy = false
+		if err := z.desc.decode(z.r); err != nil {
+			return err
+		}
+		var err error
+		if dict, err = z.dictionary(); err != nil {
+			return err
+		}
+	case lz4LegacyMagic:
+		// Legacy frames have no descriptor
+		z.legacy = true
+		z.desc = legacyDescriptor
+	default:
+		return ErrInvalidHeader
 	}
-	br := newBitReader(io.LimitReader(z.r, 3))
-	version, err := br.ReadBits(2)
-	if err != nil {
-		return err
+	if z.maxBlockSize != 0 && z.desc.BlockMaxSize > z.maxBlockSize {
+		return fmt.Errorf("%w: %d-byte blocks exceed the limit", ErrBlockTooLarge, z.desc.BlockMaxSize)
 	}
-	if version != 1 {
-		return errors.New("lz4: wrong version number")
+	if z.maxOutput != 0 && z.desc.ContentSize > uint64(z.maxOutput-z.total) {
+		return ErrOutputLimit
 	}
-	independenceFlag, err := br.ReadBit()
-	if err != nil || !independenceFlag {
-		return err
+	z.frameDict = dict
+	z.n = 0
+	z.hist = z.hist[:0]
+	if !z.desc.BlockIndependence {
+		z.hist = appendHistory(z.hist, dict)
 	}
-	blockChecksumFlag, err := br.ReadBit()
-	if err != nil {
-		return err
+	z.h.Reset()
+	return nil
+}
+
+func (z *Reader) skipFrame(nibble uint8) error {
+	var size uint32
+	if err := z.read(&size); err != nil {
+		return noEOF(err)
 	}
-	z.blockChecksumFlag = blockChecksumFlag
-	contentSizeFlag, err := br.ReadBit()
-	if err != nil || contentSizeFlag {
-		return errors.New("lz4: does not support stream size")
+	if z.skippable == nil {
+		_, err := io.CopyN(ioutil.Discard, z.r, int64(size))
+		return noEOF(err)
 	}
-	contentChecksumFlag, err := br.ReadBit()
+	data, err := ioutil.ReadAll(io.LimitReader(z.r, int64(size)))
 	if err != nil {
 		return err
 	}
-	z.contentChecksumFlag = contentChecksumFlag
-	if reserved, err := br.ReadBit(); err != nil || reserved {
-		return errors.New("lz4: wrong value for reserved bits")
+	if len(data) != int(size) {
+		return io.ErrUnexpectedEOF
 	}
-	dictionaryFlag, err := br.ReadBit()
-	if err != nil {
-		return err
+	return z.skippable(nibble, data)
+}
+
+// Header returns the descriptor of the frame being read: the first frame of
+// the stream once NewReader returns, then each of the following frames as
+// Read reaches them. Legacy frames are described with a Version of 0.
+func (z *Reader) Header() FrameDescriptor {
+	return z.desc
+}
+
+// ContentSize returns the size of the uncompressed data declared in the
+// frame header, or 0 if the frame does not declare it.
+func (z *Reader) ContentSize() uint64 {
+	return z.desc.ContentSize
+}
+
+// endFrame checks the content of the frame once its end mark is read.
+func (z *Reader) endFrame() error {
+	if z.desc.ContentSize != 0 && z.n != z.desc.ContentSize {
+		return errContentSizeCorrupt
 	}
-	if dictionaryFlag {
-		return errors.New("lz4: does not support dictionary")
+	if z.desc.ContentChecksum {
+		// Check content checksum
+		var checksum uint32
+		if err := z.read(&checksum); err != nil {
+			return noEOF(err)
+		}
+		if !z.skipChecksums && checksum != z.h.Sum32() {
+			return errContentChecksum
+		}
 	}
-	if reserved, err := br.ReadBit(); err != nil || reserved {
-		return errors.New("lz4: wrong value for reserved bits")
+	return nil
+}
+
+// noEOF converts io.EOF to io.ErrUnexpectedEOF, for reads in the middle of a
+// frame.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
 	}
-	blockMaxSize, err := br.ReadBits(3)
+	return err
+}
+
+func (z *Reader) nextBlock() {
+	if z.legacy {
+		z.nextLegacyBlock()
+		return
+	}
+	b := &z.blocks[0]
+	block, compressed, err := z.readBlock(b)
 	if err != nil {
-		return err
+		z.err = err
+		return
 	}
-	if blockMaxSize < 4 {
-		return errors.New("lz4: unsupported block size")
+	if block == nil {
+		z.err = z.nextFrame()
+		return
 	}
-	z.maxBlockSize = blockSize(blockMaxSize)
-	if reserved, err := br.ReadBits(4); err != nil || reserved != 0 {
-		return errors.New("lz4: wrong value for reserved bits")
+	if z.concurrency > 1 && z.desc.BlockIndependence {
+		z.nextBlocks(block, compressed)
+		return
 	}
-	sum := br.Sum32() >> 8 & 0xFF
-	checksum, err := br.ReadBits(8)
+	data, err := z.decodeBlock(b, block, compressed)
 	if err != nil {
+		z.err = err
+		return
+	}
+	z.err = z.addBlock(data)
+}
+
+// nextFrame checks the end of the current frame once its end mark is read,
+// and starts the next frame unless the reader stops after a single frame.
+func (z *Reader) nextFrame() error {
+	if err := z.endFrame(); err != nil {
 		return err
 	}
-	if checksum != sum {
-		return errors.New("lz4: stream descriptor error detected")
+	if z.singleFrame {
+		return io.EOF
 	}
-	z.h.Reset()
-	return nil
+	// Continue with the next frame, if any
+	return z.readFrame()
 }
 
-func (z *reader) nextBlock() {
+// readBlock reads the next block of the frame into b and checks its
+// checksum. It returns a nil block when the end mark of the frame is read.
+func (z *Reader) readBlock(b *blockBuffer) (block []byte, compressed bool, err error) {
+	z.block++
+	z.offset = z.r.n
 	// Read block size
 	var blockSize uint32
-	z.err = z.read(&blockSize)
-	if z.err != nil {
-		return
+	if err := z.read(&blockSize); err != nil {
+		return nil, false, noEOF(err)
 	}
-
-	uncompressedFlag := (blockSize >> 31) != 0
+	compressed = (blockSize >> 31) == 0
 	blockSize &= 0x7FFFFFFF
-
 	if blockSize == lz4EOM {
-		z.err = io.EOF
-		return
+		return nil, false, nil
 	}
-
-	if blockSize > z.maxBlockSize {
-		z.err = errors.New("lz4: invalid block size")
-		return
+	if blockSize > uint32(z.desc.BlockMaxSize) {
+		return nil, false, ErrBlockTooLarge
 	}
 
 	// Read block data
-	block := make([]byte, blockSize)
-	_, z.err = io.ReadFull(z.r, block)
-	if z.err != nil {
-		return
+	b.block = resize(b.block, int(blockSize))
+	block = b.block
+	if _, err := io.ReadFull(z.r, block); err != nil {
+		return nil, false, noEOF(err)
 	}
 
-	if z.blockChecksumFlag {
+	if z.desc.BlockChecksum {
 		// Check block checksum
 		var checksum uint32
-		z.err = z.read(&checksum)
-		if z.err != nil {
-			return
+		if err := z.read(&checksum); err != nil {
+			return nil, false, noEOF(err)
 		}
-		if checksum != xxhash.Checksum32(block) {
-			z.err = errors.New("lz4: invalid block checksum detected")
-			return
+		if !z.skipChecksums && checksum != xxhash.Checksum32(block) {
+			return nil, false, errBlockChecksum
 		}
 	}
+	return block, compressed, nil
+}
 
-	// Decompress
-	data := make([]byte, z.maxBlockSize)
-	if !uncompressedFlag {
-		n, err := lz4Decompress(block, data, z.maxBlockSize)
-		if err != nil {
-			z.err = err
-			return
-		}
-		data = data[0:n]
-	} else {
-		copy(data, block)
-		data = data[0:blockSize]
+// decodeBlock returns the data of block, decompressing it into b if needed.
+func (z *Reader) decodeBlock(b *blockBuffer, block []byte, compressed bool) ([]byte, error) {
+	if !compressed {
+		return block, nil
 	}
+	dict := z.hist
+	if z.desc.BlockIndependence {
+		dict = z.frameDict
+	}
+	b.data = resize(b.data, z.desc.BlockMaxSize)
+	n, err := lz4Decompress(block, b.data, dict)
+	if err != nil {
+		return nil, err
+	}
+	return b.data[:n], nil
+}
 
-	if z.contentChecksumFlag {
+// addBlock accounts for the data of a block and makes it available to Read.
+func (z *Reader) addBlock(data []byte) error {
+	if z.maxOutput != 0 && int64(len(data)) > z.maxOutput-z.total {
+		return ErrOutputLimit
+	}
+	z.total += int64(len(data))
+	z.n += uint64(len(data))
+	if z.desc.ContentSize != 0 && z.n > z.desc.ContentSize {
+		return errContentSizeCorrupt
+	}
+	if !z.desc.BlockIndependence {
+		z.hist = appendHistory(z.hist, data)
+	}
+
+	if z.desc.ContentChecksum && !z.skipChecksums {
 		z.h.Write(data)
 	}
 
-	// Add block to our history
 	z.buf = append(z.buf, data...)
+	return nil
+}
+
+// nextLegacyBlock reads a block of a legacy frame, which is always
+// compressed. Legacy frames have no end mark: a block size too large to be
+// one is the magic number of the next frame, and the stream may end instead.
+func (z *Reader) nextLegacyBlock() {
+	z.block++
+	z.offset = z.r.n
+	var blockSize uint32
+	if z.err = z.read(&blockSize); z.err != nil {
+		return
+	}
+	if blockSize > uint32(compressBound(lz4LegacyBlockSize)) {
+		if z.singleFrame {
+			z.err = io.EOF
+			return
+		}
+		z.err = z.startFrame(blockSize)
+		return
+	}
+
+	b := &z.blocks[0]
+	b.block = resize(b.block, int(blockSize))
+	if _, z.err = io.ReadFull(z.r, b.block); z.err != nil {
+		z.err = noEOF(z.err)
+		return
+	}
+	b.data = resize(b.data, lz4LegacyBlockSize)
+	n, err := lz4Decompress(b.block, b.data, nil)
+	if err != nil {
+		z.err = err
+		return
+	}
+	z.err = z.addBlock(b.data[:n])
 }
 
-func (z *reader) read(data interface{}) error {
-	return binary.Read(z.r, binary.LittleEndian, data)
+// read reads a little-endian uint32 into v, without allocating.
+func (z *Reader) read(v *uint32) error {
+	if _, err := io.ReadFull(z.r, z.scratch[:]); err != nil {
+		return err
+	}
+	*v = binary.LittleEndian.Uint32(z.scratch[:])
+	return nil
 }
 
-// Read reads a decompressed form of p from the underlying io.Reader.
-func (z *reader) Read(p []byte) (int, error) {
+// Read reads a decompressed form of p from the underlying io.Reader. The
+// content checksum of a frame is verified as soon as its end is reached, and
+// a mismatch is reported by Read instead of io.EOF.
+func (z *Reader) Read(p []byte) (int, error) {
 	for {
-		if len(z.buf) > 0 {
-			n := copy(p, z.buf)
-			z.buf = z.buf[n:]
+		if z.off < len(z.buf) {
+			n := copy(p, z.buf[z.off:])
+			z.off += n
 			return n, nil
 		}
 		if z.err != nil {
 			return 0, z.err
 		}
+		z.buf, z.off = z.buf[:0], 0
 		z.nextBlock()
+		z.err = z.wrapError(z.err)
 	}
 }
 
-// Close closes the Reader. It does not close the underlying io.Reader.
-func (z *reader) Close() error {
-	if z.contentChecksumFlag {
-		// Check content checksum
-		var checksum uint32
-		z.err = z.read(&checksum)
-		if z.err != nil || checksum != z.h.Sum32() {
-			z.err = errors.New("lz4: invalid content checksum detected")
-			return z.err
-		}
-	}
+// Close closes the Reader, returning the error that stopped it, if any. It
+// can be called several times, and does not close the underlying io.Reader.
+func (z *Reader) Close() error {
 	if z.err == io.EOF {
 		return nil
 	}
 	return z.err
 }
-
-func lz4Decompress(src []byte, dst []byte, maxSize uint32) (int, error) {
-	n := C.LZ4_decompress_safe((*C.char)(unsafe.Pointer(&src[0])),
-		(*C.char)(unsafe.Pointer(&dst[0])), C.int(len(src)), C.int(maxSize))
-	if n < 0 {
-		return 0, errors.New("lz4: data corruption")
-	}
-	return int(n), nil
-}
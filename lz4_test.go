@@ -2,11 +2,16 @@ package lz4
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"runtime"
 	"testing"
 	"testing/quick"
+
+	"github.com/vova616/xxhash"
 )
 
 type lz4Test struct {
@@ -220,10 +225,38 @@ func TestDecompressor(t *testing.T) {
 	}
 }
 
+func TestDecompressorFixture(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := ioutil.ReadFile("testdata/pg135.txt.lz4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, text) {
+		t.Errorf("got %d bytes want %d bytes", len(b), len(text))
+	}
+}
+
 func roundTrip(payload []byte) bool {
 	buf := new(bytes.Buffer)
 
-	w := NewWriter(buf)
+	w, err := NewWriter(buf)
+	if err != nil {
+		return false
+	}
 	if _, err := w.Write(payload); err != nil {
 		return false
 	}
@@ -248,6 +281,920 @@ func TestRoundTrip(t *testing.T) {
 	}
 }
 
+// frameBlocks returns the sizes of the blocks of a frame without optional
+// header fields, with the high bit set for uncompressed blocks.
+func frameBlocks(frame []byte) []uint32 {
+	var sizes []uint32
+	for p := frame[7:]; len(p) >= 4; {
+		size := binary.LittleEndian.Uint32(p)
+		if size == lz4EOM {
+			break
+		}
+		sizes = append(sizes, size)
+		p = p[4+size&0x7FFFFFFF:]
+	}
+	return sizes
+}
+
+func TestWriterBlocks(t *testing.T) {
+	payload, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{Block64KB, Block256KB, Block1MB, Block4MB} {
+		buf := new(bytes.Buffer)
+		w, err := NewWriter(buf, BlockSize(size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for p := payload; len(p) > 0; {
+			n := 100003
+			if n > len(p) {
+				n = len(p)
+			}
+			if _, err := w.Write(p[:n]); err != nil {
+				t.Fatal(err)
+			}
+			p = p[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		frame := buf.Bytes()
+		if got := blockSize(uint32(frame[5]>>4) & 0x7); got != uint32(size) {
+			t.Errorf("%d: header block size %d", size, got)
+		}
+		if got := byte(xxhash.Checksum32(frame[4:6]) >> 8); frame[6] != got {
+			t.Errorf("%d: header checksum %#x want %#x", size, frame[6], got)
+		}
+		sizes := frameBlocks(frame)
+		if want := (len(payload) + size - 1) / size; len(sizes) != want {
+			t.Errorf("%d: got %d blocks want %d", size, len(sizes), want)
+		}
+		for i, n := range sizes {
+			if n&0x7FFFFFFF > uint32(size) {
+				t.Errorf("%d: block %d: size %d too large", size, i, n&0x7FFFFFFF)
+			}
+		}
+
+		r, err := NewReader(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, payload) {
+			t.Errorf("%d: got %d bytes want %d bytes", size, len(b), len(payload))
+		}
+	}
+}
+
+func TestWriterInvalidBlockSize(t *testing.T) {
+	if _, err := NewWriter(ioutil.Discard, BlockSize(1<<10)); err == nil {
+		t.Error("expected an error for an invalid block size")
+	}
+}
+
+func TestWriterBlockChecksum(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	w, err := NewWriter(buf, BlockSize(Block256KB), BlockChecksum(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(text); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	frame := buf.Bytes()
+	if frame[4]&flagBlockChecksum == 0 {
+		t.Error("block checksum flag not set")
+	}
+	var blocks int
+	for p := frame[7:]; ; blocks++ {
+		size := binary.LittleEndian.Uint32(p)
+		if size == lz4EOM {
+			break
+		}
+		block := p[4 : 4+size&0x7FFFFFFF]
+		if got, want := binary.LittleEndian.Uint32(p[4+len(block):]), xxhash.Checksum32(block); got != want {
+			t.Errorf("block %d: checksum %#x want %#x", blocks, got, want)
+		}
+		p = p[4+len(block)+4:]
+	}
+	if want := (len(text) + Block256KB - 1) / Block256KB; blocks != want {
+		t.Errorf("got %d blocks want %d", blocks, want)
+	}
+
+	// Corrupt the first block
+	frame[7+4] ^= 0xFF
+	r, err := NewReader(bytes.NewReader(frame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Error("expected a block checksum error")
+	}
+}
+
+func TestContentSize(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	w, err := NewWriter(buf, ContentSize(uint64(len(text))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(text); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.ContentSize(); got != uint64(len(text)) {
+		t.Errorf("got content size %d want %d", got, len(text))
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, text) {
+		t.Errorf("got %d bytes want %d bytes", len(b), len(text))
+	}
+}
+
+func TestReaderHeader(t *testing.T) {
+	linked, err := ioutil.ReadFile("testdata/linked.lz4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := ioutil.ReadFile("testdata/legacy.lz4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(bytes.NewReader(append(append([]byte(nil), linked...), legacy...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := FrameDescriptor{Version: 1, ContentChecksum: true, BlockMaxSize: Block64KB}
+	if got := r.Header(); got != want {
+		t.Errorf("got %+v want %+v", got, want)
+	}
+	// Read the first frame and the first block of the second one.
+	if _, err := io.CopyN(ioutil.Discard, r, 300001); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Header(); got != legacyDescriptor {
+		t.Errorf("got %+v want %+v", got, legacyDescriptor)
+	}
+}
+
+func TestWriterContentSizeMismatch(t *testing.T) {
+	w, err := NewWriter(ioutil.Discard, ContentSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("hello")); !errors.Is(err, ErrContentSize) {
+		t.Errorf("Write: got %v want %v", err, ErrContentSize)
+	}
+
+	w, err = NewWriter(ioutil.Discard, ContentSize(6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); !errors.Is(err, ErrContentSize) {
+		t.Errorf("Close: got %v want %v", err, ErrContentSize)
+	}
+}
+
+func TestReaderContentSizeMismatch(t *testing.T) {
+	for _, size := range []uint64{4, 6} {
+		fd := FrameDescriptor{Version: 1, BlockIndependence: true, ContentSize: size, BlockMaxSize: Block64KB}
+		frame := []byte{0x4, 0x22, 0x4d, 0x18}
+		frame = append(frame, fd.encode()...)
+		frame = append(frame, 0x5, 0x0, 0x0, 0x80, 'h', 'e', 'l', 'l', 'o', 0x0, 0x0, 0x0, 0x0)
+
+		r, err := NewReader(bytes.NewReader(frame))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(r); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%d: got %v want %v", size, err, ErrCorrupt)
+		}
+	}
+}
+
+func TestDecompressorLinked(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := ioutil.ReadFile("testdata/linked.lz4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want := text[:300000]; !bytes.Equal(b, want) {
+		t.Errorf("got %d bytes want %d bytes", len(b), len(want))
+	}
+}
+
+func TestWriterLinked(t *testing.T) {
+	chunk := make([]byte, 32<<10)
+	rand.New(rand.NewSource(1)).Read(chunk)
+	payload := bytes.Repeat(chunk, 16)
+
+	compress := func(independent bool) []byte {
+		buf := new(bytes.Buffer)
+		w, err := NewWriter(buf, BlockSize(Block64KB), BlockIndependence(independent))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(payload); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	independent, linked := compress(true), compress(false)
+	if linked[4]&flagBlockIndependence != 0 {
+		t.Error("block independence flag set")
+	}
+	if len(linked) >= len(independent)/2 {
+		t.Errorf("linked frame is %d bytes, independent frame is %d bytes", len(linked), len(independent))
+	}
+
+	r, err := NewReader(bytes.NewReader(linked))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, payload) {
+		t.Errorf("got %d bytes want %d bytes", len(b), len(payload))
+	}
+}
+
+func TestWriterLevels(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text = text[:256<<10]
+
+	sizes := make(map[int]int)
+	for level := BestSpeed; level <= BestCompression; level++ {
+		buf := new(bytes.Buffer)
+		w, err := NewWriter(buf, Level(level), BlockSize(Block64KB))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(text); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		sizes[level] = buf.Len()
+
+		r, err := NewReader(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+		if !bytes.Equal(b, text) {
+			t.Errorf("level %d: got %d bytes want %d bytes", level, len(b), len(text))
+		}
+	}
+	if sizes[minHCCompression] >= sizes[BestSpeed] {
+		t.Errorf("level %d is %d bytes, level %d is %d bytes", minHCCompression, sizes[minHCCompression], BestSpeed, sizes[BestSpeed])
+	}
+	if sizes[BestCompression] > sizes[minHCCompression] {
+		t.Errorf("level %d is %d bytes, level %d is %d bytes", BestCompression, sizes[BestCompression], minHCCompression, sizes[minHCCompression])
+	}
+	for _, level := range []int{-2, BestCompression + 1} {
+		if _, err := NewWriter(ioutil.Discard, Level(level)); err == nil {
+			t.Errorf("level %d: expected an error", level)
+		}
+	}
+}
+
+func TestWriterAcceleration(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text = text[:256<<10]
+
+	var sizes []int
+	for _, acceleration := range []int{1, 8, 64} {
+		buf := new(bytes.Buffer)
+		w, err := NewWriterLevel(buf, BestSpeed, Acceleration(acceleration))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(text); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, buf.Len())
+
+		r, err := NewReader(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("acceleration %d: %v", acceleration, err)
+		}
+		if !bytes.Equal(b, text) {
+			t.Errorf("acceleration %d: got %d bytes want %d bytes", acceleration, len(b), len(text))
+		}
+	}
+	for i := 1; i < len(sizes); i++ {
+		if sizes[i] <= sizes[i-1] {
+			t.Errorf("got sizes %v, want increasing sizes", sizes)
+		}
+	}
+	if _, err := NewWriterLevel(ioutil.Discard, BestSpeed, Acceleration(0)); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestWriterFlush(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range [][]Option{
+		nil,
+		{BlockIndependence(false)},
+		{Concurrency(4)},
+	} {
+		buf := new(bytes.Buffer)
+		w, err := NewWriter(buf, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var (
+			r       *Reader
+			written int
+		)
+		for _, n := range []int{1, 1000, 100000} {
+			if _, err := w.Write(text[written : written+n]); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if r == nil {
+				if r, err = NewReader(buf); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Everything written so far can be read back.
+			b := make([]byte, n)
+			if _, err := io.ReadFull(r, b); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, text[written:written+n]) {
+				t.Errorf("got %q want %q", b, text[written:written+n])
+			}
+			written += n
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if b, err := ioutil.ReadAll(r); err != nil || len(b) != 0 {
+			t.Errorf("got %d bytes, %v", len(b), err)
+		}
+	}
+}
+
+func TestWriterClose(t *testing.T) {
+	for _, opts := range [][]Option{
+		{ContentChecksum(true)},
+		{Concurrency(4)},
+	} {
+		buf := new(bytes.Buffer)
+		w, err := NewWriter(buf, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		n := buf.Len()
+		// Closing again writes nothing, nor does using the closed writer.
+		if err := w.Close(); err != nil {
+			t.Errorf("Close: got %v want nil", err)
+		}
+		if _, err := w.Write([]byte("hello")); err != errClosed {
+			t.Errorf("Write: got %v want %v", err, errClosed)
+		}
+		if err := w.Flush(); err != errClosed {
+			t.Errorf("Flush: got %v want %v", err, errClosed)
+		}
+		if buf.Len() != n {
+			t.Errorf("got %d bytes want %d bytes", buf.Len(), n)
+		}
+		r, err := NewReader(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil || string(b) != "hello" {
+			t.Errorf("got %q, %v", b, err)
+		}
+	}
+
+	// A failed Close keeps returning its error.
+	w, err := NewWriter(ioutil.Discard, ContentSize(6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := w.Close(); !errors.Is(err, ErrContentSize) {
+			t.Errorf("Close: got %v want %v", err, ErrContentSize)
+		}
+	}
+}
+
+func TestWriterReset(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range [][]Option{
+		{BlockSize(Block64KB)},
+		{BlockSize(Block64KB), BlockIndependence(false)},
+		{BlockSize(Block64KB), Concurrency(4)},
+	} {
+		want := new(bytes.Buffer)
+		w, err := NewWriter(want, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(text); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Reset in the middle of a frame, then after closing it.
+		w, err = NewWriter(ioutil.Discard, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(text[:500000]); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			got := new(bytes.Buffer)
+			w.Reset(got)
+			if _, err := w.Write(text); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Bytes(), want.Bytes()) {
+				t.Errorf("got %d-byte frame want %d-byte frame", got.Len(), want.Len())
+			}
+		}
+	}
+}
+
+func TestReaderReset(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	w, err := NewWriter(buf, BlockSize(Block64KB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(text[:16*Block64KB]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	frame := buf.Bytes()
+
+	r, err := NewReader(bytes.NewReader(lz4Tests[0].lz4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := r.Reset(bytes.NewReader(frame)); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, text[:16*Block64KB]) {
+			t.Errorf("got %d bytes want %d bytes", len(b), 16*Block64KB)
+		}
+	}
+	if err := r.Reset(bytes.NewReader([]byte("invalid header"))); err == nil {
+		t.Error("expected an error")
+	}
+	if _, err := r.Read(make([]byte, 1)); err == nil {
+		t.Error("expected an error")
+	}
+
+	// Buffers are reused from one block and frame to the next.
+	src := bytes.NewReader(frame)
+	allocs := testing.AllocsPerRun(10, func() {
+		src.Reset(frame)
+		r.Reset(src)
+		io.Copy(ioutil.Discard, r)
+	})
+	if allocs > 10 {
+		t.Errorf("got %v allocations", allocs)
+	}
+}
+
+func TestDecompressorConcatenated(t *testing.T) {
+	var stream []byte
+	var raw string
+	for _, tt := range lz4Tests {
+		stream = append(stream, tt.lz4...)
+		raw += tt.raw
+	}
+	r, err := NewReader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != raw {
+		t.Errorf("got %d bytes want %d bytes", len(b), len(raw))
+	}
+}
+
+func TestDecompressorSingleFrame(t *testing.T) {
+	var stream []byte
+	for _, tt := range lz4Tests[1:3] {
+		stream = append(stream, tt.lz4...)
+	}
+	in := bytes.NewReader(stream)
+	r, err := NewReader(in, SingleFrame(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != lz4Tests[1].raw {
+		t.Errorf("got %q want %q", b, lz4Tests[1].raw)
+	}
+	if in.Len() != len(lz4Tests[2].lz4) {
+		t.Errorf("got %d bytes left want %d bytes", in.Len(), len(lz4Tests[2].lz4))
+	}
+}
+
+func TestDecompressorTruncated(t *testing.T) {
+	frame := lz4Tests[1].lz4
+	for _, n := range []int{len(frame) - 1, len(frame) - 6, 12} {
+		r, err := NewReader(bytes.NewReader(frame[:n]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(r); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%d: got %v want %v", n, err, io.ErrUnexpectedEOF)
+		}
+	}
+
+	// A stream cut in the header of a following frame
+	for _, n := range []int{4, 6} {
+		stream := append(append([]byte(nil), frame...), frame[:n]...)
+		r, err := NewReader(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(r); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("next frame %d: got %v want %v", n, err, io.ErrUnexpectedEOF)
+		}
+	}
+	if _, err := NewReader(bytes.NewReader(frame[:4])); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("magic: got %v want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestDecompressorLegacy(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := ioutil.ReadFile("testdata/legacy.lz4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A legacy frame followed by a regular frame
+	stream := append(compressed, lz4Tests[1].lz4...)
+	r, err := NewReader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := string(text[:300000]) + lz4Tests[1].raw; string(b) != want {
+		t.Errorf("got %d bytes want %d bytes", len(b), len(want))
+	}
+}
+
+func TestWriterContentChecksum(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		buf := new(bytes.Buffer)
+		w, err := NewWriter(buf, ContentChecksum(enabled))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(lz4Tests[1].raw)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		frame := buf.Bytes()
+		if got := frame[4]&flagContentChecksum != 0; got != enabled {
+			t.Errorf("%v: content checksum flag %v", enabled, got)
+		}
+		n := 7 + 4 + len(lz4Tests[1].raw) + 4
+		if enabled {
+			n += 4
+		}
+		if len(frame) != n {
+			t.Errorf("%v: got %d-byte frame want %d-byte frame", enabled, len(frame), n)
+		}
+
+		r, err := NewReader(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != lz4Tests[1].raw {
+			t.Errorf("%v: got %q want %q", enabled, b, lz4Tests[1].raw)
+		}
+	}
+}
+
+func TestWriterLegacy(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := bytes.Repeat(text, 3)
+
+	buf := new(bytes.Buffer)
+	w, err := NewWriter(buf, Legacy(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	frame := buf.Bytes()
+	if magic := binary.LittleEndian.Uint32(frame); magic != lz4LegacyMagic {
+		t.Errorf("got magic %#x want %#x", magic, lz4LegacyMagic)
+	}
+	var blocks int
+	for p := frame[4:]; len(p) > 0; blocks++ {
+		p = p[4+binary.LittleEndian.Uint32(p):]
+	}
+	if want := (len(payload) + lz4LegacyBlockSize - 1) / lz4LegacyBlockSize; blocks != want {
+		t.Errorf("got %d blocks want %d", blocks, want)
+	}
+
+	r, err := NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, payload) {
+		t.Errorf("got %d bytes want %d bytes", len(b), len(payload))
+	}
+}
+
+func TestWriterLegacyOptions(t *testing.T) {
+	for _, opt := range []Option{
+		BlockChecksum(true),
+		BlockIndependence(false),
+		ContentSize(42),
+		Dictionary(1, []byte("dictionary")),
+	} {
+		if _, err := NewWriter(ioutil.Discard, Legacy(true), opt); err == nil {
+			t.Error("expected an error for an option unsupported by legacy frames")
+		}
+	}
+}
+
+func TestDecompressorContentChecksum(t *testing.T) {
+	frame := append([]byte(nil), lz4Tests[4].lz4...)
+	frame[len(frame)-1] ^= 0xFF
+
+	r, err := NewReader(bytes.NewReader(frame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, r); !errors.Is(err, errContentChecksum) {
+		t.Errorf("io.Copy: got %v want %v", err, errContentChecksum)
+	}
+	for i := 0; i < 2; i++ {
+		if err := r.Close(); !errors.Is(err, errContentChecksum) {
+			t.Errorf("Close: got %v want %v", err, errContentChecksum)
+		}
+	}
+}
+
+func TestReaderVerifyChecksums(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w, err := NewWriter(buf, BlockChecksum(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(lz4Tests[4].raw)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	frame := buf.Bytes()
+	// Corrupt the block checksum and the content checksum.
+	frame[len(frame)-9] ^= 0xFF
+	frame[len(frame)-1] ^= 0xFF
+
+	for _, verify := range []bool{true, false} {
+		r, err := NewReader(bytes.NewReader(frame), VerifyChecksums(verify))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if verify {
+			if err == nil {
+				t.Error("expected an error")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != lz4Tests[4].raw {
+			t.Errorf("got %q want %q", b, lz4Tests[4].raw)
+		}
+	}
+}
+
+func TestReaderMaxBlockSize(t *testing.T) {
+	for _, tt := range []struct {
+		size  int
+		valid bool
+	}{
+		{Block64KB, false},
+		{Block4MB, true},
+	} {
+		r, err := NewReader(bytes.NewReader(lz4Tests[0].lz4), MaxBlockSize(tt.size))
+		if tt.valid && err != nil {
+			t.Errorf("%d: %v", tt.size, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%d: expected an error", tt.size)
+		}
+		if r != nil {
+			r.Close()
+		}
+	}
+	if _, err := NewReader(bytes.NewReader(lz4Tests[0].lz4), MaxBlockSize(0)); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestReaderMaxOutputSize(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text = text[:4*Block64KB]
+	compress := func(opts ...Option) []byte {
+		buf := new(bytes.Buffer)
+		w, err := NewWriter(buf, append(opts, BlockSize(Block64KB))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(text); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	frame := compress()
+	stream := append(append([]byte(nil), frame...), frame...)
+
+	// The limit applies to all the frames of the stream.
+	for _, tt := range []struct {
+		limit int64
+		want  int
+		err   error
+	}{
+		{int64(len(text)), len(text), ErrOutputLimit},
+		{int64(2 * len(text)), 2 * len(text), nil},
+		{int64(len(text)) + 1000, len(text), ErrOutputLimit},
+	} {
+		r, err := NewReader(bytes.NewReader(stream), MaxOutputSize(tt.limit))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%d: got %v want %v", tt.limit, err, tt.err)
+		}
+		if len(b) != tt.want {
+			t.Errorf("%d: got %d bytes want %d bytes", tt.limit, len(b), tt.want)
+		}
+	}
+
+	// Frames declaring a larger content size are rejected up front.
+	frame = compress(ContentSize(uint64(len(text))))
+	if _, err := NewReader(bytes.NewReader(frame), MaxOutputSize(int64(len(text))-1)); !errors.Is(err, ErrOutputLimit) {
+		t.Errorf("got %v want %v", err, ErrOutputLimit)
+	}
+}
+
 func BenchmarkDecompressor(b *testing.B) {
 	b.ReportAllocs()
 	b.StopTimer()
@@ -276,7 +1223,7 @@ func BenchmarkCompressor(b *testing.B) {
 	runtime.GC()
 	b.StartTimer()
 	for i := 0; i < b.N; i++ {
-		w := NewWriter(ioutil.Discard)
+		w, _ := NewWriter(ioutil.Discard)
 		defer w.Close()
 		io.Copy(w, bytes.NewReader(text))
 	}